@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lingua-sensei/laverna/synthesize"
+)
+
+// defaultCacheDir returns the directory of the cache under the cache directory of the user
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "laverna-cache"
+	}
+	return filepath.Join(dir, "laverna")
+}
+
+// runCache runs the cache subcommand, which is "laverna cache [flags] stats|prune|clear"
+func runCache(args []string) {
+	flags := flag.NewFlagSet("cache", flag.ExitOnError)
+	dir := flags.String("cache-dir", defaultCacheDir(), "directory of the cache")
+	size := flags.Int64("cache-size", 1024, "maximum size of the cache in MiB that prune evicts down to, 0 means unlimited")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: laverna cache [flags] stats|prune|clear\n")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args) // exits on error
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cache := synthesize.NewCache(*dir, *size<<20)
+	switch flags.Arg(0) {
+	case "stats":
+		stats, err := cache.Stats()
+		if err != nil {
+			fatal("failed to read cache", "err", err)
+		}
+		fmt.Printf("dir: %s\nentries: %d\nsize: %.1f MiB\n", *dir, stats.Entries, float64(stats.Size)/(1<<20))
+	case "prune":
+		removed, err := cache.Prune()
+		if err != nil {
+			fatal("failed to prune cache", "err", err)
+		}
+		fmt.Printf("removed %d entries, %.1f MiB\n", removed.Entries, float64(removed.Size)/(1<<20))
+	case "clear":
+		if err := cache.Clear(); err != nil {
+			fatal("failed to clear cache", "err", err)
+		}
+		fmt.Println("cleared")
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+}
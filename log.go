@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+// verbosity is a flag that counts how many times it is set, so -v -v is more verbose than -v,
+// it can also be set to a number such as -v=2
+type verbosity int
+
+func (v *verbosity) String() string {
+	if v == nil {
+		return "0"
+	}
+	return strconv.Itoa(int(*v))
+}
+
+func (v *verbosity) Set(s string) error {
+	if s == "true" {
+		*v++
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return fmt.Errorf("verbosity(%s) must be a non-negative number", s)
+	}
+	*v = verbosity(n)
+	return nil
+}
+
+func (v *verbosity) IsBoolFlag() bool { return true }
+
+// newLogger returns a logger that writes to w in the text or json format,
+// info and above is logged by default, -v adds the debug logs and -v -v adds the source of each log
+func newLogger(w io.Writer, format string, v verbosity) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if v > 0 {
+		opts.Level = slog.LevelDebug
+	}
+	opts.AddSource = v > 1
+
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("log format(%s) must be text or json", format)
+	}
+}
+
+// fatal logs msg with args at error level and exits with 1
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
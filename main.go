@@ -1,51 +1,404 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/lingua-sensei/laverna/synthesize"
 )
 
 var (
-	filenamePath = flag.String("file", "", "filename path that is used for reading YAML file")
+	filenamePath = flag.String("file", "", "filename path that is used for reading YAML, CSV or JSONL file")
 	maxWorkers   = flag.Int("workers", runtime.GOMAXPROCS(0), "maximum number of concurrent downloads")
+	outDir       = flag.String("out", ".", "directory the audios are written to, a .zip archive or s3://bucket/prefix for an S3 compatible object store")
+	s3Endpoint   = flag.String("s3-endpoint", "", "base URL of the object store of an s3:// -out such as http://localhost:9000, Amazon S3 by default")
+	s3Region     = flag.String("s3-region", os.Getenv("AWS_REGION"), "region of the object store of an s3:// -out")
+	nameTmpl     = flag.String("name", "", "Go template of the audio filenames such as '{{.Voice}}/{{.Index}}-{{.Slug}}.mp3', fields are Index, Voice, Speed, Text, Slug, Hash and Extra")
+	adaptive     = flag.Bool("adaptive", false, "adapt the number of concurrent downloads up to -workers, backing off when throttled")
+	baseURL      = flag.String("base-url", synthesize.DefaultBaseURL, "base URL of the translate endpoint such as a mirror or a proxy")
+	retries      = flag.Int("retries", synthesize.DefaultRetryPolicy.MaxAttempts, "maximum number of attempts for each row, 1 disables retries")
+	retryDelay   = flag.Duration("retry-delay", synthesize.DefaultRetryPolicy.BaseDelay, "delay before the first retry, it doubles on every retry")
+	retryMax     = flag.Duration("retry-max-delay", synthesize.DefaultRetryPolicy.MaxDelay, "maximum delay between retries")
+	retryJitter  = flag.Float64("retry-jitter", synthesize.DefaultRetryPolicy.Jitter, "fraction of the retry delay in [0, 1] that is randomized")
+	rate         = flag.Float64("rate", 0, "maximum number of requests per second, 0 means unlimited")
+	burst        = flag.Int("burst", 1, "maximum number of requests sent at once when -rate is set")
+	keepGoing    = flag.Bool("keep-going", false, "attempt every row even if some fail, then report the failures and exit non-zero")
+	failedPath   = flag.String("failed", "", "file the failed rows are written to as YAML, JSONL or CSV by its extension so they can be run again, it implies -keep-going")
+	skipExisting = flag.Bool("skip-existing", false, "skip the rows whose audio already exists in -out, which resumes an interrupted run")
+	statePath    = flag.String("state", "", "file that records the finished rows so running again resumes where it stopped")
+	useCache     = flag.Bool("cache", false, "keep the audios in -cache-dir and reuse them instead of downloading the same text again")
+	cacheDir     = flag.String("cache-dir", defaultCacheDir(), "directory of the cache")
+	cacheSize    = flag.Int64("cache-size", 1024, "maximum size of the cache in MiB, the least recently used audios are evicted past it, 0 means unlimited")
+	manifestPath = flag.String("manifest", "", "file that lists every row with its audio file, size, SHA-256, duration and status, as CSV if it ends with .csv and JSON otherwise")
+	showProgress = flag.Bool("progress", true, "show the progress as a bar on a terminal or as log lines every -progress-interval otherwise")
+	progressTick = flag.Duration("progress-interval", 10*time.Second, "interval of the progress log lines when the output isn't a terminal")
+	stream       = flag.Bool("stream", false, "read the rows one by one while running instead of loading the whole file first, for very big files")
+	tags         = flag.Bool("tags", false, "write ID3v2.4 tags into the audios, the title is the text, the language is the one of the voice, the comment is the speed and the track is the row, title, album, artist and track columns override them")
+	tagAlbum     = flag.String("tag-album", "", "album of the ID3 tags, it implies -tags")
+	tagArtist    = flag.String("tag-artist", "", "artist of the ID3 tags, it implies -tags")
+	tagCover     = flag.String("tag-cover", "", "JPEG or PNG image attached as the cover of the ID3 tags, it implies -tags")
+	logFormat    = flag.String("log-format", "text", "format of the logs written to stderr, text or json")
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "cache" {
+		runCache(os.Args[2:])
+		return
+	}
+
+	var verbose verbosity
+	flag.Var(&verbose, "v", "log the requests and every row at debug level, repeat it as -v -v to add the source of each log")
 	flag.Parse()
+	logger, err := newLogger(os.Stderr, *logFormat, verbose)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
 	if *filenamePath == "" {
 		flag.Usage()
 		os.Exit(0)
 	}
 
-	raw, err := os.ReadFile(*filenamePath)
+	if !isYAML(*filenamePath) && !strings.HasSuffix(*filenamePath, ".csv") && !strings.HasSuffix(*filenamePath, ".jsonl") {
+		fatal("file format must be yaml/yml, csv or jsonl", "file", *filenamePath)
+	}
+
+	runnerOpts := []synthesize.BatchRunnerOption{
+		synthesize.WithMaxWorkers(*maxWorkers),
+		synthesize.WithSynthesizer(&synthesize.Client{BaseURL: *baseURL, Logger: logger}),
+		synthesize.WithRetry(synthesize.RetryPolicy{
+			MaxAttempts: *retries,
+			BaseDelay:   *retryDelay,
+			MaxDelay:    *retryMax,
+			Jitter:      *retryJitter,
+		}),
+		synthesize.WithRateLimit(*rate, *burst),
+		synthesize.WithLogger(logger),
+	}
+	if *adaptive {
+		runnerOpts = append(runnerOpts, synthesize.WithAdaptiveConcurrency(1, *maxWorkers))
+	}
+	saver, closeSaver, err := newSaver(*outDir, *statePath != "" || *skipExisting)
 	if err != nil {
-		log.Fatalf("[ERR] failed to read filename path: %v", err)
+		fatal("failed to open output", "out", *outDir, "err", err)
+	}
+	if saver != nil {
+		runnerOpts = append(runnerOpts, synthesize.WithSaver(saver))
+	} else {
+		runnerOpts = append(runnerOpts, synthesize.WithOutputDir(*outDir))
+	}
+	if *nameTmpl != "" {
+		filename, err := synthesize.FilenameTemplate(*nameTmpl)
+		if err != nil {
+			fatal("failed to parse filename template", "err", err)
+		}
+		runnerOpts = append(runnerOpts, synthesize.WithFilenameFunc(filename))
+	}
+
+	if *keepGoing || *failedPath != "" {
+		runnerOpts = append(runnerOpts, synthesize.WithContinueOnError())
 	}
 
-	var opts []synthesize.Opt
-	if strings.HasSuffix(*filenamePath, ".yaml") || strings.HasSuffix(*filenamePath, ".yml") {
-		opts, err = synthesize.UnmarshalYAML(raw)
+	if *useCache {
+		runnerOpts = append(runnerOpts, synthesize.WithCache(synthesize.NewCache(*cacheDir, *cacheSize<<20)))
+	}
+	if *tags || *tagAlbum != "" || *tagArtist != "" || *tagCover != "" {
+		tagging := synthesize.Tagging{Album: *tagAlbum, Artist: *tagArtist}
+		if *tagCover != "" {
+			cover, err := os.ReadFile(*tagCover)
+			if err != nil {
+				fatal("failed to read cover", "err", err)
+			}
+			if mime := http.DetectContentType(cover); mime != "image/jpeg" && mime != "image/png" {
+				fatal("cover must be a JPEG or PNG image", "file", *tagCover, "type", mime)
+			}
+			tagging.Cover = cover
+		}
+		runnerOpts = append(runnerOpts, synthesize.WithTagging(tagging))
+	}
+	if *skipExisting {
+		runnerOpts = append(runnerOpts, synthesize.WithSkipExisting())
+	}
+	if *statePath != "" {
+		state, err := synthesize.OpenState(*statePath)
 		if err != nil {
-			log.Fatalf("[ERR] failed to unmarshal YAML: %v", err)
+			fatal("failed to open state", "err", err)
+		}
+		defer func() {
+			if err := state.Close(); err != nil {
+				slog.Error("failed to close state", "err", err)
+			}
+		}()
+		if n := state.Len(); n > 0 {
+			slog.Info("resuming", "finished", n)
 		}
-	} else if strings.HasSuffix(*filenamePath, ".csv") {
-		opts, err = synthesize.UnmarshalCSV(raw)
+		runnerOpts = append(runnerOpts, synthesize.WithState(state))
+	}
+
+	var (
+		manifest     *synthesize.Manifest
+		manifestFile *os.File
+	)
+	if *manifestPath != "" {
+		f, err := os.Create(*manifestPath)
 		if err != nil {
-			log.Fatalf("[ERR] failed to unmarshal CSV: %v", err)
+			fatal("failed to create manifest", "err", err)
+		}
+		manifestFile = f
+		format := synthesize.ManifestJSON
+		if strings.HasSuffix(*manifestPath, ".csv") {
+			format = synthesize.ManifestCSV
 		}
+		manifest = synthesize.NewManifest(f, format)
+		runnerOpts = append(runnerOpts, synthesize.WithManifest(manifest))
+	}
+
+	var progress *progressPrinter
+	if *showProgress {
+		progress = newProgressPrinter(*progressTick)
+		runnerOpts = append(runnerOpts, synthesize.WithProgress(progress.handle))
+	}
+
+	// an interrupt stops the batch and lets the output, the manifest and the progress finish as usual,
+	// a second one exits right away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	defer context.AfterFunc(ctx, func() {
+		slog.Warn("interrupted, stopping the batch")
+		stop()
+	})()
+
+	runner := synthesize.NewBatchRunner(runnerOpts...)
+	var readErr error
+	if *stream {
+		f, openErr := os.Open(*filenamePath)
+		if openErr != nil {
+			fatal("failed to open filename path", "err", openErr)
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				slog.Error("failed to close filename path", "err", err)
+			}
+		}()
+		decoder := newDecoder(*filenamePath, f)
+		err = runner.RunSeq(ctx, decoder.All())
+		readErr = decoder.Err()
 	} else {
-		log.Fatalf("[ERR] file format must be yaml/yml or csv")
-		return
+		opts, loadErr := readOpts(*filenamePath)
+		if loadErr != nil {
+			fatal("failed to read rows", "err", loadErr)
+		}
+		err = runner.Run(ctx, opts)
+	}
+	if progress != nil {
+		progress.done()
+	}
+	outErr := closeSaver()
+	if outErr != nil {
+		slog.Error("failed to close output", "out", *outDir, "err", outErr)
+	}
+	if readErr != nil {
+		slog.Error("failed to read rows", "err", readErr)
+	}
+	if manifest != nil {
+		if err := closeManifest(manifest, manifestFile); err != nil {
+			slog.Error("failed to write manifest", "err", err)
+		} else {
+			slog.Info("manifest is written", "file", *manifestPath)
+		}
+	}
+	if *adaptive {
+		slog.Info("adaptive concurrency settled", "workers", runner.Concurrency())
+	}
+	var batchErr *synthesize.BatchError
+	if errors.As(err, &batchErr) {
+		if *failedPath != "" {
+			if err := writeFailed(*failedPath, batchErr.Opts()); err != nil {
+				slog.Error("failed to write failed rows", "err", err)
+			} else {
+				slog.Info("failed rows are written", "file", *failedPath)
+			}
+		}
+		// every failure is already logged by the runner as it happens, so this only sums them up
+		rows := make([]int, len(batchErr.Failures))
+		for i, f := range batchErr.Failures {
+			rows[i] = f.Index + 1
+		}
+		fatal("batch failed", "failed", len(batchErr.Failures), "total", batchErr.Total, "rows", rows)
+	}
+	if err != nil {
+		fatal("failed to run batch", "err", err)
 	}
+	if outErr != nil || readErr != nil {
+		os.Exit(1)
+	}
+}
+
+// closeManifest finishes the manifest and closes its file
+func closeManifest(m *synthesize.Manifest, f *os.File) error {
+	err := m.Close()
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("%T.Close(%s): %w", f, f.Name(), closeErr)
+	}
+	return err
+}
+
+// newSaver returns the Saver of out and a function that finishes its output, the Saver is nil for a directory,
+// a run that resumes carries the files of an existing zip archive over
+func newSaver(out string, resume bool) (synthesize.Saver, func() error, error) {
+	switch {
+	case strings.HasPrefix(out, "s3://"):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(out, "s3://"), "/")
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		return &synthesize.S3Saver{
+			Endpoint:        *s3Endpoint,
+			Bucket:          bucket,
+			Prefix:          prefix,
+			Region:          *s3Region,
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, func() error { return nil }, nil
+	case strings.HasSuffix(out, ".zip"):
+		return newZipSaver(out, resume)
+	default:
+		return nil, func() error { return nil }, nil
+	}
+}
+
+// newZipSaver returns a ZipSaver that writes to a temporary file which is renamed over out once it is finished,
+// so the archive at out stays readable until then and a run that resumes can carry its files over
+func newZipSaver(out string, resume bool) (synthesize.Saver, func() error, error) {
+	var prev *zip.ReadCloser
+	if resume {
+		zr, err := zip.OpenReader(out)
+		switch {
+		case err == nil:
+			prev = zr
+		case !errors.Is(err, fs.ErrNotExist):
+			return nil, nil, fmt.Errorf("zip.OpenReader(%s): %w", out, err)
+		}
+	}
+	f, err := os.CreateTemp(filepath.Dir(out), "."+filepath.Base(out)+".tmp-*")
+	if err != nil {
+		return nil, nil, errors.Join(fmt.Errorf("os.CreateTemp(%s): %w", out, err), closeZip(prev))
+	}
+	saver := synthesize.NewZipSaver(f)
+	if prev != nil {
+		saver.Resume(&prev.Reader)
+	}
+	return saver, func() error {
+		err := saver.Close()
+		if closeErr := f.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("%T.Close(%s): %w", f, f.Name(), closeErr)
+		}
+		if closeErr := closeZip(prev); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(f.Name())
+			return err
+		}
+		if err := os.Rename(f.Name(), out); err != nil {
+			return fmt.Errorf("os.Rename(%s, %s): %w", f.Name(), out, err)
+		}
+		return nil
+	}, nil
+}
+
+// closeZip closes zr if it is open
+func closeZip(zr *zip.ReadCloser) error {
+	if zr == nil {
+		return nil
+	}
+	if err := zr.Close(); err != nil {
+		return fmt.Errorf("%T.Close(): %w", zr, err)
+	}
+	return nil
+}
+
+// readOpts reads every row of the file at path, CSV and YAML rows are checked all at once
+func readOpts(path string) ([]synthesize.Opt, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read filename path: %w", err)
+	}
+
+	switch {
+	case isYAML(path):
+		opts, err := synthesize.UnmarshalYAML(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal YAML: %w", err)
+		}
+		return opts, nil
+	case strings.HasSuffix(path, ".csv"):
+		opts, err := synthesize.UnmarshalCSV(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal CSV: %w", err)
+		}
+		return opts, nil
+	default:
+		decoder := synthesize.NewJSONLDecoder(bytes.NewReader(raw))
+		opts := slices.Collect(decoder.All())
+		if err := decoder.Err(); err != nil {
+			return nil, fmt.Errorf("failed to decode JSONL: %w", err)
+		}
+		return opts, nil
+	}
+}
+
+// newDecoder returns the Decoder for the file format of path
+func newDecoder(path string, r io.Reader) *synthesize.Decoder {
+	switch {
+	case isYAML(path):
+		return synthesize.NewYAMLDecoder(r)
+	case strings.HasSuffix(path, ".csv"):
+		return synthesize.NewCSVDecoder(r)
+	default:
+		return synthesize.NewJSONLDecoder(r)
+	}
+}
 
-	runner := synthesize.NewBatchRunner(synthesize.WithMaxWorkers(*maxWorkers))
-	if err := runner.Run(context.Background(), opts); err != nil {
-		log.Fatalf("[ERR] failed to run batch: %v", err)
+// isYAML reports whether path is a YAML file by its extension
+func isYAML(path string) bool {
+	return strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml")
+}
+
+// writeFailed writes the failed rows to path as YAML, JSONL or CSV by its extension
+func writeFailed(path string, opts []synthesize.Opt) error {
+	marshal := synthesize.MarshalCSV
+	if isYAML(path) {
+		marshal = synthesize.MarshalYAML
+	} else if strings.HasSuffix(path, ".jsonl") {
+		marshal = synthesize.MarshalJSONL
+	}
+	raw, err := marshal(opts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal failed rows: %w", err)
+	}
+	if err := os.WriteFile(path, raw, 0600); err != nil {
+		return fmt.Errorf("os.WriteFile(%s): %w", path, err)
 	}
+	return nil
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lingua-sensei/laverna/synthesize"
+)
+
+// barWidth is the number of characters of the progress bar
+const barWidth = 30
+
+// progressPrinter shows the progress of a batch as a bar that is redrawn on a terminal,
+// or as a log line every interval otherwise, even while the batch is stalled
+type progressPrinter struct {
+	w     io.Writer
+	tty   bool
+	start time.Time
+	stop  chan struct{}
+	ticks sync.WaitGroup
+
+	mu       sync.Mutex
+	last     time.Time
+	progress synthesize.Progress
+}
+
+// newProgressPrinter returns a progressPrinter that draws a bar if stderr is a terminal
+func newProgressPrinter(interval time.Duration) *progressPrinter {
+	info, err := os.Stderr.Stat()
+	p := &progressPrinter{
+		w:     os.Stderr,
+		tty:   err == nil && info.Mode()&os.ModeCharDevice != 0,
+		start: time.Now(),
+		stop:  make(chan struct{}),
+	}
+	if !p.tty && interval > 0 {
+		p.ticks.Add(1)
+		go p.tick(interval)
+	}
+	return p
+}
+
+// tick logs the progress every interval until done is called
+func (p *progressPrinter) tick(interval time.Duration) {
+	defer p.ticks.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.Lock()
+			p.print()
+			p.mu.Unlock()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// handle is the callback of synthesize.WithProgress
+func (p *progressPrinter) handle(e synthesize.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.progress = e.Progress
+	if !p.tty || time.Since(p.last) < 100*time.Millisecond {
+		return
+	}
+	p.last = time.Now()
+	p.print()
+}
+
+// done prints the final progress
+func (p *progressPrinter) done() {
+	close(p.stop)
+	p.ticks.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.print()
+	if p.tty {
+		fmt.Fprintln(p.w)
+	}
+}
+
+// print prints the progress, p.mu must be held
+func (p *progressPrinter) print() {
+	pr := p.progress
+	if !p.tty {
+		// the elapsed time goes on while no event comes
+		slog.Info("progress", "done", pr.Done(), "total", pr.Total, "failed", pr.Failed, "retries", pr.Retries,
+			"elapsed", time.Since(p.start).Round(time.Second), "eta", pr.ETA.Round(time.Second))
+		return
+	}
+
+	status := fmt.Sprintf("%d done", pr.Done())
+	if pr.Total > 0 {
+		status = fmt.Sprintf("%d/%d", pr.Done(), pr.Total)
+	}
+	status += fmt.Sprintf(", %d failed, %d retries, %s elapsed", pr.Failed, pr.Retries, pr.Elapsed.Round(time.Second))
+	if pr.ETA > 0 {
+		status += fmt.Sprintf(", eta %s", pr.ETA.Round(time.Second))
+	}
+
+	bar := ""
+	if pr.Total > 0 {
+		filled := barWidth * pr.Done() / pr.Total
+		bar = fmt.Sprintf("[%s%s] ", strings.Repeat("#", filled), strings.Repeat("-", barWidth-filled))
+	}
+	// clear the rest of the line since the status may get shorter
+	fmt.Fprintf(p.w, "\r%s%s\033[K", bar, status)
+}
@@ -0,0 +1,120 @@
+package synthesize
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// latencyTolerance is how many times slower than the fastest request a request can be while still counting as healthy,
+// latencySlack keeps very fast requests from being judged by noise
+const (
+	latencyTolerance = 2
+	latencySlack     = 50 * time.Millisecond
+)
+
+// adaptiveLimit bounds how many requests run at once and adapts the bound with AIMD:
+// it grows by one after a full window of healthy requests and halves when the upstream shows throttling
+type adaptiveLimit struct {
+	min, max int
+
+	mu          sync.Mutex
+	limit       int
+	inFlight    int
+	successes   int
+	minLatency  time.Duration
+	decreasedAt time.Time
+	changed     chan struct{}
+}
+
+func newAdaptiveLimit(minLimit, maxLimit int) *adaptiveLimit {
+	minLimit = max(minLimit, 1)
+	maxLimit = max(maxLimit, minLimit)
+	return &adaptiveLimit{
+		min:     minLimit,
+		max:     maxLimit,
+		limit:   minLimit,
+		changed: make(chan struct{}),
+	}
+}
+
+// acquire blocks until a request may start or ctx is done, a nil adaptiveLimit never blocks
+func (a *adaptiveLimit) acquire(ctx context.Context) error {
+	if a == nil {
+		return ctx.Err()
+	}
+
+	for {
+		a.mu.Lock()
+		if a.inFlight < a.limit {
+			a.inFlight++
+			a.mu.Unlock()
+			return nil
+		}
+		changed := a.changed
+		a.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release ends a request that started at start and adapts the limit to how it went
+func (a *adaptiveLimit) release(start time.Time, err error) {
+	if a == nil {
+		return
+	}
+	latency := time.Since(start)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inFlight--
+
+	switch {
+	case isThrottled(err):
+		// requests that were already running when the limit was cut saw the same congestion, so they are ignored
+		if start.After(a.decreasedAt) {
+			a.limit = max(a.limit/2, a.min)
+			a.successes = 0
+			a.decreasedAt = time.Now()
+		}
+	case err == nil:
+		if a.minLatency == 0 || latency < a.minLatency {
+			a.minLatency = latency
+		}
+		if latency <= max(latencyTolerance*a.minLatency, a.minLatency+latencySlack) {
+			a.successes++
+			if a.successes >= a.limit {
+				a.limit = min(a.limit+1, a.max)
+				a.successes = 0
+			}
+		}
+	}
+
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+// current returns the current limit
+func (a *adaptiveLimit) current() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.limit
+}
+
+// isThrottled reports whether err shows that the upstream is overloaded or throttling
+func isThrottled(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrRateLimited) || errors.Is(err, ErrUnavailable) || errors.Is(err, ErrNoAudio) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,103 @@
+package synthesize
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lingua-sensei/laverna/synthesize/synthesizetest"
+)
+
+func TestAdaptiveLimit(t *testing.T) {
+	a := newAdaptiveLimit(2, 4)
+	succeed := func(n int) {
+		for range n {
+			if err := a.acquire(t.Context()); err != nil {
+				t.Fatalf("%T.acquire(): %v", a, err)
+			}
+			a.release(time.Now(), nil)
+		}
+	}
+
+	succeed(2)
+	if got := a.current(); got != 3 {
+		t.Fatalf("%T.current(): got = %d after a window of successes, want = %d", a, got, 3)
+	}
+	succeed(3 + 4 + 4)
+	if got := a.current(); got != 4 {
+		t.Fatalf("%T.current(): got = %d, want it capped at %d", a, got, 4)
+	}
+
+	start := time.Now()
+	a.inFlight += 2
+	a.release(start, ErrRateLimited)
+	a.release(start, ErrRateLimited) // same congestion event, it must not cut the limit again
+	if got := a.current(); got != 2 {
+		t.Fatalf("%T.current(): got = %d after throttling, want = %d", a, got, 2)
+	}
+
+	a.inFlight++
+	a.release(time.Now(), ErrNoAudio)
+	if got := a.current(); got != 2 {
+		t.Fatalf("%T.current(): got = %d, want it floored at %d", a, got, 2)
+	}
+
+	a.inFlight++
+	a.release(time.Now(), ErrUnknownVoice)
+	if got := a.current(); got != 2 {
+		t.Fatalf("%T.current(): got = %d after a permanent error, want = %d", a, got, 2)
+	}
+}
+
+func TestAdaptiveLimit_acquireBlocks(t *testing.T) {
+	a := newAdaptiveLimit(1, 1)
+	if err := a.acquire(t.Context()); err != nil {
+		t.Fatalf("%T.acquire(): %v", a, err)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	if err := a.acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("%T.acquire(): got err = %v, want = %v", a, err, context.DeadlineExceeded)
+	}
+
+	go a.release(time.Now(), nil)
+	if err := a.acquire(t.Context()); err != nil {
+		t.Fatalf("%T.acquire(): %v after release", a, err)
+	}
+}
+
+func TestBatchRunner_WithAdaptiveConcurrency(t *testing.T) {
+	faults := make([]synthesizetest.Fault, 10)
+	for i := range faults {
+		faults[i] = synthesizetest.RateLimited
+	}
+	server := synthesizetest.NewServer(synthesizetest.WithFaults(faults...))
+	defer server.Close()
+
+	var opts []Opt
+	for i := range 20 {
+		opts = append(opts, Opt{Text: fmt.Sprintf("test%d", i), Voice: EnglishVoice})
+	}
+	runner := NewBatchRunner(
+		WithSynthesizer(&Client{BaseURL: server.URL}),
+		WithAdaptiveConcurrency(1, 8),
+		WithRetry(RetryPolicy{MaxAttempts: 20, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+		WithSaveFunc(func(string, []byte) error { return nil }),
+	)
+	if err := runner.Run(t.Context(), opts); err != nil {
+		t.Fatalf("%T.Run(): %v", runner, err)
+	}
+	if got := runner.Concurrency(); got < 1 || got > 8 {
+		t.Errorf("%T.Concurrency(): got = %d, want between 1 and 8", runner, got)
+	}
+
+	runner = NewBatchRunner(WithMaxWorkers(3))
+	if err := runner.Run(t.Context(), nil); err != nil {
+		t.Fatalf("%T.Run(): %v", runner, err)
+	}
+	if got := runner.Concurrency(); got != 3 {
+		t.Errorf("%T.Concurrency(): got = %d, want = %d", runner, got, 3)
+	}
+}
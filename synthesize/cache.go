@@ -0,0 +1,285 @@
+package synthesize
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// staleTempAge is how old a temporary file of the cache must be before Prune removes it,
+// younger ones may still be written to
+const staleTempAge = time.Hour
+
+// evictTarget is the share of its maximum size that a cache is evicted down to,
+// which leaves room for the next writes so they don't walk the cache again one by one
+const evictTarget = 0.9
+
+// Cache keeps synthesized audio in files under a directory so the same text isn't synthesized twice,
+// every file starts with the SHA-256 of its audio so a damaged file is detected and dropped.
+// The least recently used files are evicted once the cache grows past its maximum size.
+// A BatchRunner keys the audio by the Backend method of its synthesizer if it has one like Client does, or by its type otherwise
+type Cache struct {
+	dir     string
+	maxSize int64
+
+	mu   sync.Mutex
+	size int64 // bytes on disk, -1 until it is known
+}
+
+// NewCache returns a Cache in dir that keeps up to maxSize bytes, 0 means no limit
+func NewCache(dir string, maxSize int64) *Cache {
+	return &Cache{dir: dir, maxSize: maxSize, size: -1}
+}
+
+// CacheKey returns the key of the audio of opt produced by backend,
+// the text is normalized so that differences in whitespace don't miss the cache
+func CacheKey(backend string, opt Opt) string {
+	text := strings.Join(strings.Fields(opt.Text), " ")
+	sum := sha256.Sum256([]byte(backend + "\x00" + string(opt.Voice) + "\x00" + opt.Speed.String() + "\x00" + text))
+	return hex.EncodeToString(sum[:])
+}
+
+// path returns the file of key, the files are spread over directories named after the first byte of their key
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// Get returns the audio cached under key, it marks the audio as recently used
+// and reports false if there is none or its file is damaged, damaged files are removed
+func (c *Cache) Get(key string) ([]byte, bool) {
+	path := c.path(key)
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	audio, ok := verifyEntry(raw)
+	if !ok {
+		c.remove(path, int64(len(raw)))
+		return nil, false
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(path, now, now) // an entry that keeps its old time is only evicted sooner
+	return audio, true
+}
+
+// Put caches audio under key and evicts the least recently used audio if the cache grows past its maximum size
+func (c *Cache) Put(key string, audio []byte) error {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return fmt.Errorf("os.MkdirAll(%s): %w", filepath.Dir(path), err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("os.CreateTemp(%s): %w", filepath.Dir(path), err)
+	}
+	defer func() {
+		_ = os.Remove(f.Name()) // fails once the file is renamed
+	}()
+	sum := sha256.Sum256(audio)
+	if _, err := f.Write(append(sum[:], audio...)); err != nil {
+		_ = f.Close() // the write error is the one that matters
+		return fmt.Errorf("%T.Write(): %w", f, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("%T.Close(): %w", f, err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("os.Rename(%s, %s): %w", f.Name(), path, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size >= 0 {
+		c.size += int64(len(sum) + len(audio))
+	}
+	if c.maxSize > 0 && (c.size < 0 || c.size > c.maxSize) {
+		_, err := c.evict(false)
+		return err
+	}
+	return nil
+}
+
+// verifyEntry returns the audio of a cache file if its checksum matches
+func verifyEntry(raw []byte) ([]byte, bool) {
+	if len(raw) < sha256.Size {
+		return nil, false
+	}
+	sum := sha256.Sum256(raw[sha256.Size:])
+	return raw[sha256.Size:], bytes.Equal(sum[:], raw[:sha256.Size])
+}
+
+// remove removes a cache file of size bytes
+func (c *Cache) remove(path string, size int64) {
+	if os.Remove(path) != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size >= 0 {
+		c.size -= size
+	}
+}
+
+// CacheStats describes the files of a cache
+type CacheStats struct {
+	Entries int
+	Size    int64
+}
+
+// cacheEntry is a file of the cache
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+	temp    bool
+}
+
+// entries lists the files of the cache, files that the cache didn't write are left out
+func (c *Cache) entries() ([]cacheEntry, error) {
+	var entries []cacheEntry
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == c.dir {
+			return fs.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != c.dir && (filepath.Dir(path) != c.dir || len(d.Name()) != 2) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		temp := strings.HasPrefix(d.Name(), ".tmp-")
+		if !temp && (len(d.Name()) != 2*sha256.Size || !strings.HasPrefix(d.Name(), filepath.Base(filepath.Dir(path)))) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, cacheEntry{path: path, size: info.Size(), modTime: info.ModTime(), temp: temp})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("filepath.WalkDir(%s): %w", c.dir, err)
+	}
+	return entries, nil
+}
+
+// Stats returns the number and the size of the cached audio
+func (c *Cache) Stats() (CacheStats, error) {
+	entries, err := c.entries()
+	if err != nil {
+		return CacheStats{}, err
+	}
+	var stats CacheStats
+	for _, e := range entries {
+		if !e.temp {
+			stats.Entries++
+			stats.Size += e.size
+		}
+	}
+	return stats, nil
+}
+
+// Prune removes damaged files and leftovers of interrupted writes,
+// then evicts the least recently used audio like Put does if the cache is past its maximum size, it returns what was removed
+func (c *Cache) Prune() (CacheStats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.evict(true)
+}
+
+// evict removes the least recently used files down to evictTarget of the maximum size once the cache grows past it,
+// and damaged and stale temporary files too if verify is set, c.mu must be held
+func (c *Cache) evict(verify bool) (CacheStats, error) {
+	entries, err := c.entries()
+	if err != nil {
+		return CacheStats{}, err
+	}
+
+	var removed CacheStats
+	var size int64
+	kept := entries[:0]
+	for _, e := range entries {
+		switch {
+		case e.temp && verify && time.Since(e.modTime) > staleTempAge:
+			if err := os.Remove(e.path); err != nil {
+				return removed, fmt.Errorf("os.Remove(%s): %w", e.path, err)
+			}
+		case e.temp:
+		case verify && !c.valid(e.path):
+			if err := os.Remove(e.path); err != nil {
+				return removed, fmt.Errorf("os.Remove(%s): %w", e.path, err)
+			}
+			removed.Entries++
+			removed.Size += e.size
+		default:
+			kept = append(kept, e)
+			size += e.size
+		}
+	}
+
+	if c.maxSize <= 0 || size <= c.maxSize {
+		c.size = size
+		return removed, nil
+	}
+	target := int64(float64(c.maxSize) * evictTarget)
+	slices.SortFunc(kept, func(a, b cacheEntry) int { return a.modTime.Compare(b.modTime) })
+	for _, e := range kept {
+		if size <= target {
+			break
+		}
+		if err := os.Remove(e.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, fmt.Errorf("os.Remove(%s): %w", e.path, err)
+		}
+		size -= e.size
+		removed.Entries++
+		removed.Size += e.size
+	}
+	c.size = size
+	return removed, nil
+}
+
+// valid reports whether the cache file at path passes its integrity check
+func (c *Cache) valid(path string) bool {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	_, ok := verifyEntry(raw)
+	return ok
+}
+
+// Clear removes all the cached audio, the directory is kept along with any file the cache didn't write
+func (c *Cache) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.Remove(e.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("os.Remove(%s): %w", e.path, err)
+		}
+		// the directory of the entry is removed once it is empty
+		_ = os.Remove(filepath.Dir(e.path))
+	}
+	c.size = 0
+	return nil
+}
@@ -0,0 +1,244 @@
+package synthesize
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lingua-sensei/laverna/synthesize/synthesizetest"
+)
+
+func TestCacheKey(t *testing.T) {
+	opt := Opt{Text: "Hello there", Voice: EnglishVoice}
+	key := CacheKey("backend", opt)
+
+	tests := []struct {
+		name    string
+		backend string
+		opt     Opt
+		same    bool
+	}{
+		{
+			name:    "whitespace is normalized",
+			backend: "backend",
+			opt:     Opt{Text: " Hello\t there\n", Voice: EnglishVoice},
+			same:    true,
+		},
+		{
+			name:    "other backend",
+			backend: "other",
+			opt:     opt,
+		},
+		{
+			name:    "other voice",
+			backend: "backend",
+			opt:     Opt{Text: "Hello there", Voice: ThaiVoice},
+		},
+		{
+			name:    "other speed",
+			backend: "backend",
+			opt:     Opt{Text: "Hello there", Voice: EnglishVoice, Speed: SlowerSpeed},
+		},
+		{
+			name:    "case matters",
+			backend: "backend",
+			opt:     Opt{Text: "hello there", Voice: EnglishVoice},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CacheKey(tt.backend, tt.opt) == key; got != tt.same {
+				t.Errorf("CacheKey(%q, %v) == CacheKey(%q, %v): got = %t, want = %t", tt.backend, tt.opt, "backend", opt, got, tt.same)
+			}
+		})
+	}
+}
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	entrySize := int64(sha256.Size + 100)
+	// the cache is evicted down to 90% of its maximum size, which still fits 2 entries
+	c := NewCache(dir, 5*entrySize/2)
+	audio := func(b byte) []byte { return bytes.Repeat([]byte{b}, 100) }
+	keyA, keyB, keyC := CacheKey("", Opt{Text: "a"}), CacheKey("", Opt{Text: "b"}), CacheKey("", Opt{Text: "c"})
+
+	if _, ok := c.Get(keyA); ok {
+		t.Fatalf("%T.Get(): got a hit in an empty cache", c)
+	}
+	for _, key := range []string{keyA, keyB} {
+		if err := c.Put(key, audio(key[0])); err != nil {
+			t.Fatalf("%T.Put(): %v", c, err)
+		}
+		// mtimes are too coarse on some filesystems to tell apart entries written in a row
+		old := time.Now().Add(-time.Hour)
+		if err := os.Chtimes(c.path(key), old, old); err != nil {
+			t.Fatalf("os.Chtimes(): %v", err)
+		}
+	}
+	if got, ok := c.Get(keyA); !ok || !bytes.Equal(got, audio(keyA[0])) {
+		t.Fatalf("%T.Get(): got = %q, %t, want the audio that was put", c, got, ok)
+	}
+
+	// a is used more recently than b, so b is evicted
+	if err := c.Put(keyC, audio(keyC[0])); err != nil {
+		t.Fatalf("%T.Put(): %v", c, err)
+	}
+	if _, ok := c.Get(keyB); ok {
+		t.Errorf("%T.Get(b): got a hit, want it evicted", c)
+	}
+	stats, err := c.Stats()
+	if err != nil {
+		t.Fatalf("%T.Stats(): %v", c, err)
+	}
+	if diff := cmp.Diff(CacheStats{Entries: 2, Size: 2 * entrySize}, stats); diff != "" {
+		t.Errorf("%T.Stats(): diff=\n%s", c, diff)
+	}
+
+	// a damaged entry is dropped by Prune
+	raw, err := os.ReadFile(c.path(keyC))
+	if err != nil {
+		t.Fatalf("os.ReadFile(): %v", err)
+	}
+	raw[len(raw)-1] ^= 0xFF
+	if err := os.WriteFile(c.path(keyC), raw, 0600); err != nil {
+		t.Fatalf("os.WriteFile(): %v", err)
+	}
+	removed, err := c.Prune()
+	if err != nil {
+		t.Fatalf("%T.Prune(): %v", c, err)
+	}
+	if diff := cmp.Diff(CacheStats{Entries: 1, Size: entrySize}, removed); diff != "" {
+		t.Errorf("%T.Prune(): removed diff=\n%s", c, diff)
+	}
+	if _, ok := c.Get(keyC); ok {
+		t.Errorf("%T.Get(c): got a hit, want the damaged entry gone", c)
+	}
+
+	// Clear leaves the files it didn't write
+	other := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(other, []byte("mine"), 0600); err != nil {
+		t.Fatalf("os.WriteFile(): %v", err)
+	}
+	if err := c.Clear(); err != nil {
+		t.Fatalf("%T.Clear(): %v", c, err)
+	}
+	if stats, err := c.Stats(); err != nil || stats != (CacheStats{}) {
+		t.Errorf("%T.Stats(): got = %+v, %v after Clear, want it empty", c, stats, err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("os.Stat(%q): %v, want it kept", other, err)
+	}
+
+	if stats, err := NewCache(filepath.Join(dir, "missing"), 0).Stats(); err != nil || stats != (CacheStats{}) {
+		t.Errorf("%T.Stats(): got = %+v, %v for a missing directory, want it empty", c, stats, err)
+	}
+}
+
+func TestClient_Cache(t *testing.T) {
+	server := synthesizetest.NewServer()
+	defer server.Close()
+
+	client := &Client{HTTPClient: server.Client(), Cache: NewCache(t.TempDir(), 0)}
+	opt := Opt{Text: "hello", Voice: EnglishVoice}
+	for range 2 {
+		audio, err := client.Synthesize(t.Context(), opt)
+		if err != nil {
+			t.Fatalf("%T.Synthesize(): %v", client, err)
+		}
+		if want := synthesizetest.Audio(opt.Text, 0); !bytes.Equal(audio, want) {
+			t.Errorf("%T.Synthesize(): got %d bytes, want %d", client, len(audio), len(want))
+		}
+	}
+	if got := len(server.Requests()); got != 1 {
+		t.Errorf("%T.Synthesize(): got %d requests, want %d", client, got, 1)
+	}
+}
+
+func TestBatchRunner_WithCache(t *testing.T) {
+	server := synthesizetest.NewServer()
+	defer server.Close()
+	cache := NewCache(t.TempDir(), 0)
+
+	runner := NewBatchRunner(
+		WithClient(server.Client()),
+		WithCache(cache),
+		WithSaveFunc(func(string, []byte) error { return nil }),
+	)
+	opts := []Opt{{Text: "hello", Voice: EnglishVoice}}
+	for _, wantCached := range []bool{false, true} {
+		results, err := runner.RunResults(t.Context(), opts)
+		if err != nil {
+			t.Fatalf("%T.RunResults(): %v", runner, err)
+		}
+		if results[0].Cached != wantCached {
+			t.Errorf("%T.RunResults(): got cached = %t, want = %t", runner, results[0].Cached, wantCached)
+		}
+	}
+	if got := len(server.Requests()); got != 1 {
+		t.Errorf("%T.RunResults(): got %d requests, want %d", runner, got, 1)
+	}
+}
+
+func TestBatchRunner_WithCache_PutFails(t *testing.T) {
+	server := synthesizetest.NewServer()
+	defer server.Close()
+	// the cache can't create its directories under a file
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatalf("os.WriteFile(): %v", err)
+	}
+
+	dir := t.TempDir()
+	runner := NewBatchRunner(WithClient(server.Client()), WithCache(NewCache(file, 0)), WithOutputDir(dir))
+	if err := runner.Run(t.Context(), []Opt{{Text: "hello", Voice: EnglishVoice}}); err != nil {
+		t.Fatalf("%T.Run(): %v", runner, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "hello_en_normal.mp3")); err != nil {
+		t.Errorf("os.Stat(): got %v, want the audio saved even though it isn't cached", err)
+	}
+
+	client := &Client{HTTPClient: server.Client(), Cache: NewCache(file, 0)}
+	if _, err := client.Synthesize(t.Context(), Opt{Text: "hello", Voice: EnglishVoice}); err != nil {
+		t.Errorf("%T.Synthesize(): %v", client, err)
+	}
+}
+
+func TestCache_evictTarget(t *testing.T) {
+	entrySize := int64(sha256.Size + 100)
+	c := NewCache(t.TempDir(), 10*entrySize)
+	put := func(i int) {
+		key := CacheKey("", Opt{Text: strconv.Itoa(i)})
+		if err := c.Put(key, bytes.Repeat([]byte{byte(i)}, 100)); err != nil {
+			t.Fatalf("%T.Put(): %v", c, err)
+		}
+		// mtimes are too coarse on some filesystems to tell apart entries written in a row
+		old := time.Now().Add(time.Duration(i-100) * time.Minute)
+		if err := os.Chtimes(c.path(key), old, old); err != nil {
+			t.Fatalf("os.Chtimes(): %v", err)
+		}
+	}
+	wantEntries := func(want int) {
+		t.Helper()
+		stats, err := c.Stats()
+		if err != nil {
+			t.Fatalf("%T.Stats(): %v", c, err)
+		}
+		if stats.Entries != want {
+			t.Errorf("%T.Stats(): got entries(%d), want entries(%d)", c, stats.Entries, want)
+		}
+	}
+
+	// growing past the maximum size evicts down to 90% of it, so the next write fits without evicting
+	for i := range 11 {
+		put(i)
+	}
+	wantEntries(9)
+	put(11)
+	wantEntries(10)
+}
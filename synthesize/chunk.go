@@ -0,0 +1,144 @@
+package synthesize
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Chunk splits text into pieces that are no longer than limit so each piece can be synthesized on its own.
+// Text is split at sentence boundaries first, then at clause boundaries, then at whitespace.
+// Scripts that are written without spaces (Thai, Japanese, Chinese, ...) are finally split between characters,
+// words of other scripts are never broken, so a single word longer than limit is returned as its own chunk.
+func Chunk(text string, limit int) []string {
+	if TextLength(text) <= limit {
+		return []string{text}
+	}
+
+	var chunks []string
+	for _, chunk := range chunkLevel(text, limit, 0) {
+		if chunk = strings.TrimSpace(chunk); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks
+}
+
+// boundaries are applied in order, from the most natural pause to the least natural one
+var boundaries = []func(text string, i int, r rune) bool{
+	isSentenceBoundary,
+	isClauseBoundary,
+	isSpaceBoundary,
+	isCharBoundary,
+}
+
+// chunkLevel splits text at the boundary of the given level and greedily packs the pieces into chunks,
+// pieces that are still too long are split again with the next level
+func chunkLevel(text string, limit, level int) []string {
+	if TextLength(text) <= limit || level == len(boundaries) {
+		return []string{text}
+	}
+
+	var chunks []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+	}
+	for _, piece := range splitAfter(text, boundaries[level]) {
+		if TextLength(current.String())+TextLength(piece) > limit {
+			flush()
+		}
+		if current.Len() == 0 {
+			piece = strings.TrimLeftFunc(piece, unicode.IsSpace)
+		}
+		if TextLength(piece) > limit {
+			chunks = append(chunks, chunkLevel(piece, limit, level+1)...)
+			continue
+		}
+		current.WriteString(piece)
+	}
+	flush()
+	return chunks
+}
+
+// splitAfter slices text into pieces that end right after every position where isBoundary reports true
+func splitAfter(text string, isBoundary func(text string, i int, r rune) bool) []string {
+	var pieces []string
+	start := 0
+	for i, r := range text {
+		end := runeEnd(text, i)
+		if isBoundary(text, i, r) {
+			pieces = append(pieces, text[start:end])
+			start = end
+		}
+	}
+	if start < len(text) {
+		pieces = append(pieces, text[start:])
+	}
+	return pieces
+}
+
+func isSentenceBoundary(text string, i int, r rune) bool {
+	switch r {
+	case '\n', '。', '！', '？', '।', '॥', '။', '።', '؟', '۔', '๚':
+		return true
+	case '.', '!', '?', '…':
+		// avoid splitting numbers such as 3.14 or 1,000
+		return followedBySpace(text, i, r)
+	}
+	return false
+}
+
+func isClauseBoundary(text string, i int, r rune) bool {
+	switch r {
+	case '、', '，', '；', '：', '،', '؛', '၊':
+		return true
+	case ',', ';', ':', '—', '–':
+		return followedBySpace(text, i, r)
+	}
+	return false
+}
+
+func isSpaceBoundary(text string, i int, r rune) bool {
+	return unicode.IsSpace(r) && !followedBySpace(text, i, r)
+}
+
+// isCharBoundary allows breaking after a character of a script written without spaces,
+// as long as the next character is not a combining mark that belongs to it
+func isCharBoundary(text string, i int, r rune) bool {
+	next, size := utf8.DecodeRuneInString(text[runeEnd(text, i):])
+	if size == 0 || unicode.In(next, unicode.Mn, unicode.Mc) {
+		return false
+	}
+	return isUnspacedScript(r) || isUnspacedScript(next)
+}
+
+// unspacedScripts are written without spaces between words
+var unspacedScripts = []*unicode.RangeTable{
+	unicode.Han,
+	unicode.Hiragana,
+	unicode.Katakana,
+	unicode.Thai,
+	unicode.Lao,
+	unicode.Khmer,
+	unicode.Myanmar,
+	unicode.Tibetan,
+}
+
+func isUnspacedScript(r rune) bool {
+	return unicode.In(r, unspacedScripts...) || r == 'ー' || r == '々'
+}
+
+func followedBySpace(text string, i int, _ rune) bool {
+	next, size := utf8.DecodeRuneInString(text[runeEnd(text, i):])
+	return size == 0 || unicode.IsSpace(next)
+}
+
+// runeEnd returns the index right after the rune at i, an invalid byte is a rune of its own like it is for range
+func runeEnd(text string, i int) int {
+	_, size := utf8.DecodeRuneInString(text[i:])
+	return i + size
+}
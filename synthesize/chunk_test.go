@@ -0,0 +1,110 @@
+package synthesize
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestChunk(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{
+			name:  "short text",
+			text:  "Hello there",
+			limit: 200,
+			want:  []string{"Hello there"},
+		},
+		{
+			name:  "sentences",
+			text:  "The cat sat. The dog ran! Did the bird fly?",
+			limit: 20,
+			want:  []string{"The cat sat.", "The dog ran!", "Did the bird fly?"},
+		},
+		{
+			name:  "sentences are packed together",
+			text:  "One. Two. Three. Four.",
+			limit: 12,
+			want:  []string{"One. Two.", "Three. Four."},
+		},
+		{
+			name:  "numbers are not sentence boundaries",
+			text:  "Pi is 3.14 and so on. Yes.",
+			limit: 22,
+			want:  []string{"Pi is 3.14 and so on.", "Yes."},
+		},
+		{
+			name:  "clauses",
+			text:  "first clause, second clause; third clause",
+			limit: 16,
+			want:  []string{"first clause,", "second clause;", "third clause"},
+		},
+		{
+			name:  "words",
+			text:  "one two three four five",
+			limit: 9,
+			want:  []string{"one two", "three", "four five"},
+		},
+		{
+			name:  "long word is kept whole",
+			text:  "a supercalifragilistic word",
+			limit: 10,
+			want:  []string{"a", "supercalifragilistic", "word"},
+		},
+		{
+			name:  "japanese sentences",
+			text:  "こんにちは。元気ですか？",
+			limit: 8,
+			want:  []string{"こんにちは。", "元気ですか？"},
+		},
+		{
+			name:  "japanese without punctuation",
+			text:  "ありがとうございます",
+			limit: 4,
+			want:  []string{"ありがと", "うござい", "ます"},
+		},
+		{
+			name:  "thai keeps combining marks",
+			text:  "สวัสดีครับ",
+			limit: 3,
+			want:  []string{"สวั", "สดี", "ครั", "บ"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Chunk(tt.text, tt.limit)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Chunk(%q, %d): diff=\n%s", tt.text, tt.limit, diff)
+			}
+		})
+	}
+}
+
+func TestChunk_Limit(t *testing.T) {
+	text := strings.Repeat("สวัสดีชาวโลก วันนี้เราจะมาพูดคุยกันถึงปัญหาของโลก ", 20)
+	chunks := Chunk(text, MaxTextLength)
+	if len(chunks) < 2 {
+		t.Fatalf("Chunk(): got %d chunks, want more than 1", len(chunks))
+	}
+	for _, chunk := range chunks {
+		if TextLength(chunk) > MaxTextLength {
+			t.Errorf("Chunk(): chunk(%q) is longer than %d", chunk, MaxTextLength)
+		}
+	}
+	if got, want := strings.Join(chunks, ""), strings.ReplaceAll(text, " ", ""); strings.ReplaceAll(got, " ", "") != want {
+		t.Errorf("Chunk(): joined chunks(%q) lost text(%q)", got, want)
+	}
+}
+
+func TestChunk_InvalidUTF8(t *testing.T) {
+	text := strings.Repeat("\xff", 250) + " " + strings.Repeat("\xff", 10)
+	chunks := Chunk(text, MaxTextLength)
+	if got := strings.Join(chunks, " "); got != text {
+		t.Errorf("Chunk(): joined chunks(%q), want text(%q)", got, text)
+	}
+}
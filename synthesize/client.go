@@ -0,0 +1,251 @@
+package synthesize
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the base URL of Google Translate
+const DefaultBaseURL = "https://translate.google.com"
+
+// DefaultRPCID is the RPC id of the text to speech call of batchexecute
+const DefaultRPCID = "jQ1olc"
+
+const batchexecutePath = "/_/TranslateWebserverUi/data/batchexecute"
+
+// Client produces audio with the batchexecute endpoint of Google Translate, the zero value is ready to use
+type Client struct {
+	// HTTPClient sends the requests, http.DefaultClient is used if it is nil
+	HTTPClient *http.Client
+	// BaseURL is where the endpoint is served such as a mirror, a regional domain or a proxy gateway,
+	// DefaultBaseURL is used if it is empty
+	BaseURL string
+	// Header is added to every request, it overrides the default headers with the same keys
+	Header http.Header
+	// RPCID is the RPC id of the text to speech call, DefaultRPCID is used if it is empty
+	RPCID string
+	// Cache is consulted before sending any request and keeps the audio that is produced, nothing is cached if it is nil
+	Cache *Cache
+	// Logger logs every request at debug level and the responses that can't be parsed at warn level,
+	// nothing is logged if it is nil
+	Logger *slog.Logger
+	// Limiter is waited on before every request, so long text takes a token for each of its chunks,
+	// requests aren't limited if it is nil
+	Limiter *Limiter
+}
+
+// Run produces the audio with a http client and a given option, it is a shortcut for Client.Synthesize
+func Run(ctx context.Context, c *http.Client, opt Opt) ([]byte, error) {
+	client := &Client{HTTPClient: c}
+	return client.Synthesize(ctx, opt)
+}
+
+// Synthesize produces the audio of a given option,
+// long text is chunked and the audio of the chunks is stitched into one MP3.
+// It fails with ErrInvalidAudio if the upstream replies with something that isn't MP3, see ParseMP3
+func (c *Client) Synthesize(ctx context.Context, opt Opt) ([]byte, error) {
+	if !opt.Voice.Valid() {
+		return nil, fmt.Errorf("voice(%q): %w", opt.Voice, ErrUnknownVoice)
+	}
+	if !opt.Speed.Valid() {
+		return nil, fmt.Errorf("speed(%d): %w", opt.Speed, ErrUnknownSpeed)
+	}
+	chunks, err := chunkText(opt.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	var key string
+	if c.Cache != nil {
+		key = CacheKey(c.Backend(), opt)
+		if audio, ok := c.Cache.Get(key); ok {
+			c.logger().DebugContext(ctx, "cache hit", "voice", opt.Voice, "speed", opt.Speed.String(), "key", key)
+			return audio, nil
+		}
+	}
+
+	parts := make([][]byte, 0, len(chunks))
+	for _, chunk := range chunks {
+		if err := c.Limiter.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("%T.Wait(): %w", c.Limiter, err)
+		}
+		chunkOpt := opt
+		chunkOpt.Text = chunk
+		audio, err := c.synthesize(ctx, chunkOpt)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, audio)
+	}
+	audio := joinMP3(parts)
+
+	if c.Cache != nil {
+		// the cache only saves requests, so the audio is returned even if it can't be cached
+		if err := c.Cache.Put(key, audio); err != nil {
+			c.logger().WarnContext(ctx, "cache put failed", "key", key, "err", err)
+		}
+	}
+	return audio, nil
+}
+
+// Backend identifies the upstream in cache keys, it is the base URL and the RPC id
+func (c *Client) Backend() string {
+	return c.baseURL() + "#" + c.rpcID()
+}
+
+func (c *Client) baseURL() string {
+	if baseURL := strings.TrimSuffix(c.BaseURL, "/"); baseURL != "" {
+		return baseURL
+	}
+	return DefaultBaseURL
+}
+
+func (c *Client) logger() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return slog.New(slog.DiscardHandler)
+}
+
+func (c *Client) rpcID() string {
+	if c.RPCID != "" {
+		return c.RPCID
+	}
+	return DefaultRPCID
+}
+
+// synthesize produces the audio of a single request
+func (c *Client) synthesize(ctx context.Context, opt Opt) (_ []byte, err error) {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	baseURL, rpcID := c.baseURL(), c.rpcID()
+
+	formData, err := makeFormData(rpcID, opt)
+	if err != nil {
+		return nil, fmt.Errorf("makeFormData(%v): %v", opt, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+batchexecutePath, bytes.NewBufferString(formData))
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequestWithContext(): %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "*/*")
+	req.Header.Set("Origin", baseURL)
+	req.Header.Set("Referer", baseURL)
+	for key, values := range c.Header {
+		req.Header[http.CanonicalHeaderKey(key)] = values
+	}
+
+	logger := c.logger().With("voice", opt.Voice, "speed", opt.Speed.String(), "length", TextLength(opt.Text))
+	logger.DebugContext(ctx, "request started", "url", req.URL.String())
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		logger.DebugContext(ctx, "request failed", "latency", time.Since(start), "err", err)
+		return nil, fmt.Errorf("%T.Do(): %w", httpClient, err)
+	}
+	defer func() {
+		closeErr := resp.Body.Close()
+		if err == nil && closeErr != nil {
+			err = fmt.Errorf("resp.Body.Close(): %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		upstreamErr := newUpstreamError(resp)
+		logger.DebugContext(ctx, "request finished", "status", resp.StatusCode, "latency", time.Since(start), "err", upstreamErr)
+		return nil, upstreamErr
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.DebugContext(ctx, "request failed", "status", resp.StatusCode, "latency", time.Since(start), "err", err)
+		return nil, fmt.Errorf("io.ReadAll(): %w", err)
+	}
+	logger.DebugContext(ctx, "request finished", "status", resp.StatusCode, "latency", time.Since(start), "bytes", len(raw))
+
+	audio, err := parseAudio(raw)
+	if err == nil {
+		_, err = ParseMP3(audio)
+	}
+	if err != nil {
+		logger.WarnContext(ctx, "response parse failed", "bytes", len(raw), "err", err)
+		return nil, err
+	}
+	return audio, nil
+}
+
+// ErrRateLimited occurs when the upstream replies with HTTP 429 Too Many Requests
+var ErrRateLimited = errors.New("rate limited")
+
+// ErrUnavailable occurs when the upstream replies with a HTTP 5xx status
+var ErrUnavailable = errors.New("upstream unavailable")
+
+// UpstreamError occurs when the upstream replies with a status other than 2xx,
+// it matches ErrRateLimited or ErrUnavailable with errors.Is depending on the status
+type UpstreamError struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int
+	// RetryAfter is how long the upstream asked to wait before trying again, zero if it didn't ask
+	RetryAfter time.Duration
+	// Body is the beginning of the response body
+	Body string
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("upstream status(%d %s): %q", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// Is reports whether the error matches one of the sentinel errors of its status
+func (e *UpstreamError) Is(target error) bool {
+	switch target {
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUnavailable:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// maxBodySnippet is the number of bytes of the body UpstreamError keeps
+const maxBodySnippet = 512
+
+func newUpstreamError(resp *http.Response) *UpstreamError {
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, maxBodySnippet+1))
+	body := string(raw)
+	if len(raw) > maxBodySnippet {
+		body = strings.ToValidUTF8(string(raw[:maxBodySnippet]), "") + "..."
+	}
+
+	return &UpstreamError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		Body:       body,
+	}
+}
+
+// parseRetryAfter parses the Retry-After header, which is either in seconds or a HTTP date
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
@@ -0,0 +1,164 @@
+package synthesize
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lingua-sensei/laverna/synthesize/synthesizetest"
+	"github.com/mrwormhole/errdiff"
+)
+
+func TestClient_Synthesize(t *testing.T) {
+	server := synthesizetest.NewServer()
+	defer server.Close()
+
+	opt := Opt{Text: "Hello there", Voice: EnglishVoice, Speed: SlowerSpeed}
+	tests := []struct {
+		name      string
+		client    *Client
+		opt       Opt
+		wantErr   error
+		wantBytes int
+	}{
+		{
+			name:      "base URL",
+			client:    &Client{BaseURL: server.URL},
+			opt:       opt,
+			wantBytes: len(synthesizetest.Audio(opt.Text, int(opt.Speed))),
+		},
+		{
+			name:      "base URL with trailing slash",
+			client:    &Client{BaseURL: server.URL + "/"},
+			opt:       opt,
+			wantBytes: len(synthesizetest.Audio(opt.Text, int(opt.Speed))),
+		},
+		{
+			name:      "default base URL",
+			client:    &Client{HTTPClient: server.Client()},
+			opt:       opt,
+			wantBytes: len(synthesizetest.Audio(opt.Text, int(opt.Speed))),
+		},
+		{
+			name:    "unknown voice",
+			client:  &Client{BaseURL: server.URL},
+			opt:     Opt{Text: "Hello there", Voice: "xx"},
+			wantErr: ErrUnknownVoice,
+		},
+		{
+			name:    "unknown speed",
+			client:  &Client{BaseURL: server.URL},
+			opt:     Opt{Text: "Hello there", Voice: "en", Speed: 3},
+			wantErr: ErrUnknownSpeed,
+		},
+		{
+			name:    "unknown RPC id",
+			client:  &Client{BaseURL: server.URL, RPCID: "unknown"},
+			opt:     opt,
+			wantErr: errors.New(`upstream status(400 Bad Request): "rpc id(unknown) is not jQ1olc\n"`),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audio, err := tt.client.Synthesize(t.Context(), tt.opt)
+			if diff := errdiff.Check(err, tt.wantErr); diff != "" {
+				t.Errorf("%T.Synthesize(%v): err diff=\n%s", tt.client, tt.opt, diff)
+			}
+			if len(audio) != tt.wantBytes {
+				t.Errorf("%T.Synthesize(%v): got bytes(%d), want bytes(%d)", tt.client, tt.opt, len(audio), tt.wantBytes)
+			}
+		})
+	}
+}
+
+func TestClient_Header(t *testing.T) {
+	server := synthesizetest.NewServer()
+	defer server.Close()
+
+	var mu sync.Mutex
+	var got http.Header
+	transport := server.Client().Transport
+	client := &Client{
+		HTTPClient: &http.Client{
+			Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				mu.Lock()
+				got = req.Header.Clone()
+				mu.Unlock()
+				return transport.RoundTrip(req)
+			}),
+		},
+		BaseURL: server.URL,
+		Header: http.Header{
+			"Authorization": {"Bearer token"},
+			"referer":       {"https://example.com"},
+		},
+	}
+
+	opt := Opt{Text: "hello", Voice: EnglishVoice}
+	if _, err := client.Synthesize(t.Context(), opt); err != nil {
+		t.Fatalf("%T.Synthesize(%v): %v", client, opt, err)
+	}
+
+	want := http.Header{
+		"Accept":        {"*/*"},
+		"Authorization": {"Bearer token"},
+		"Content-Type":  {"application/x-www-form-urlencoded"},
+		"Origin":        {server.URL},
+		"Referer":       {"https://example.com"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("%T.Synthesize(%v): header diff=\n%s", client, opt, diff)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, time.May, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: 0},
+		{value: "120", want: 2 * time.Minute},
+		{value: "-5", want: 0},
+		{value: "Thu, 01 May 2025 12:00:30 GMT", want: 30 * time.Second},
+		{value: "Thu, 01 May 2025 11:00:00 GMT", want: 0},
+		{value: "soon", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value, now); got != tt.want {
+				t.Errorf("parseRetryAfter(%q): got = %v, want = %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewUpstreamError(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusBadGateway,
+		Header:     http.Header{"Retry-After": {"3"}},
+		Body:       io.NopCloser(strings.NewReader(strings.Repeat("ก", maxBodySnippet))),
+	}
+	err := newUpstreamError(resp)
+
+	if !errors.Is(err, ErrUnavailable) || errors.Is(err, ErrRateLimited) {
+		t.Errorf("newUpstreamError(): got err = %v, want it to match only %v", err, ErrUnavailable)
+	}
+	if err.RetryAfter != 3*time.Second {
+		t.Errorf("%T.RetryAfter: got = %v, want = %v", err, err.RetryAfter, 3*time.Second)
+	}
+	if want := strings.Repeat("ก", maxBodySnippet/3) + "..."; err.Body != want {
+		t.Errorf("%T.Body: got = %q, want = %q", err, err.Body, want)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,188 @@
+package synthesize
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Decoder reads Opts from CSV, YAML or JSONL one row at a time, so big files don't have to be loaded in memory
+type Decoder struct {
+	next  func() (map[string]string, error)
+	index int
+	err   error
+}
+
+// Decode returns the Opt of the next row, it returns io.EOF when there are no more rows.
+// Like UnmarshalCSV and UnmarshalYAML it fails on rows whose text is too long to be synthesized
+func (d *Decoder) Decode() (Opt, error) {
+	if d.err != nil {
+		return Opt{}, d.err
+	}
+
+	row, err := d.next()
+	if err == io.EOF {
+		d.err = err
+		return Opt{}, err
+	}
+	if err != nil {
+		d.err = fmt.Errorf("row(%d): %w", d.index+1, err)
+		return Opt{}, d.err
+	}
+	opt := newOpt(row)
+	if err := checkRow(d.index, opt); err != nil {
+		d.err = err
+		return Opt{}, err
+	}
+	d.index++
+	return opt, nil
+}
+
+// All returns the rows left as a sequence of Opts that stops at the first error, which Err reports
+func (d *Decoder) All() iter.Seq[Opt] {
+	return func(yield func(Opt) bool) {
+		for {
+			opt, err := d.Decode()
+			if err != nil || !yield(opt) {
+				return
+			}
+		}
+	}
+}
+
+// Err returns the error that stopped the decoder, it is nil if the decoder reached the end of the rows
+func (d *Decoder) Err() error {
+	if d.err == io.EOF {
+		return nil
+	}
+	return d.err
+}
+
+// NewCSVDecoder returns a Decoder that reads CSV with the same header as UnmarshalCSV
+func NewCSVDecoder(r io.Reader) *Decoder {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	var header []string
+	return &Decoder{next: func() (map[string]string, error) {
+		if header == nil {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return nil, ErrEmptyCSV
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%T.Read(): %w", reader, err)
+			}
+			if err := checkHeader(record); err != nil {
+				return nil, err
+			}
+			header = slices.Clone(record)
+		}
+
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%T.Read(): %w", reader, err)
+		}
+		row := make(map[string]string, len(header))
+		for i, column := range header {
+			row[column] = record[i]
+		}
+		return row, nil
+	}}
+}
+
+// NewYAMLDecoder returns a Decoder that reads YAML with the same rows as UnmarshalYAML,
+// the rows must be the items of a top-level block list that start with "- " at the beginning of a line
+func NewYAMLDecoder(r io.Reader) *Decoder {
+	reader := bufio.NewReader(r)
+	var (
+		item []byte // the lines of the row being read
+		rows int
+	)
+	decode := func() (map[string]string, error) {
+		var in []map[string]any
+		if err := yaml.Unmarshal(item, &in); err != nil {
+			return nil, fmt.Errorf("yaml.Unmarshal(): %w", err)
+		}
+		rows++
+		if len(in) != 1 {
+			return nil, fmt.Errorf("item(%q) is not a single row", item)
+		}
+		return stringRow(in[0]), nil
+	}
+
+	return &Decoder{next: func() (map[string]string, error) {
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("%T.ReadBytes(): %w", reader, err)
+			}
+			eof := err == io.EOF
+
+			switch {
+			case isYAMLItem(line) && item != nil:
+				row, err := decode()
+				item = line
+				return row, err
+			case isYAMLItem(line):
+				item = line
+			case item != nil:
+				item = append(item, line...)
+			case !isYAMLBlank(line):
+				return nil, fmt.Errorf("line(%q): yaml rows must be the items of a top-level list", line)
+			}
+
+			if eof {
+				if item != nil {
+					row, err := decode()
+					item = nil
+					return row, err
+				}
+				if rows == 0 {
+					return nil, ErrEmptyYAML
+				}
+				return nil, io.EOF
+			}
+		}
+	}}
+}
+
+// isYAMLItem reports whether line starts an item of a top-level list
+func isYAMLItem(line []byte) bool {
+	return bytes.HasPrefix(line, []byte("-")) && (len(line) == 1 || strings.ContainsRune(" \t\r\n", rune(line[1])))
+}
+
+// isYAMLBlank reports whether line is empty, a comment or a document marker, which may come before the first item
+func isYAMLBlank(line []byte) bool {
+	line = bytes.TrimSpace(line)
+	return len(line) == 0 || line[0] == '#' || bytes.Equal(line, []byte("---"))
+}
+
+// NewJSONLDecoder returns a Decoder that reads JSON Lines, every line is an object with the same keys as a YAML row
+func NewJSONLDecoder(r io.Reader) *Decoder {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	return &Decoder{next: func() (map[string]string, error) {
+		var in map[string]any
+		err := decoder.Decode(&in)
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%T.Decode(): %w", decoder, err)
+		}
+		return stringRow(in), nil
+	}}
+}
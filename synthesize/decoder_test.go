@@ -0,0 +1,115 @@
+package synthesize
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mrwormhole/errdiff"
+)
+
+func TestDecoder(t *testing.T) {
+	example := []Opt{
+		{Speed: NormalSpeed, Voice: ThaiVoice, Text: "สวัสดีครับ"},
+		{Speed: SlowerSpeed, Voice: EnglishVoice, Text: "Hello there"},
+		{Speed: SlowestSpeed, Voice: JapaneseVoice, Text: "こんにちは~"},
+	}
+	readFile := func(filename string) string {
+		raw, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("os.ReadFile(%s): %v", filename, err)
+		}
+		return string(raw)
+	}
+	tooLong := strings.Repeat("a", MaxTextLength+1)
+
+	tests := []struct {
+		name     string
+		decoder  func(string) *Decoder
+		raw      string
+		wantOpts []Opt
+		wantErr  error
+	}{
+		{
+			name:     "example CSV",
+			decoder:  func(raw string) *Decoder { return NewCSVDecoder(strings.NewReader(raw)) },
+			raw:      readFile("../testdata/synthesize-example.csv"),
+			wantOpts: example,
+		},
+		{
+			name:     "example YAML",
+			decoder:  func(raw string) *Decoder { return NewYAMLDecoder(strings.NewReader(raw)) },
+			raw:      readFile("../testdata/synthesize-example.yaml"),
+			wantOpts: example,
+		},
+		{
+			name:    "JSONL",
+			decoder: func(raw string) *Decoder { return NewJSONLDecoder(strings.NewReader(raw)) },
+			raw: `{"speed": "normal", "voice": "th", "text": "สวัสดีครับ"}
+{"speed": "slower", "voice": "EN", "text": "Hello there", "lesson": 1, "note": null}
+`,
+			wantOpts: []Opt{
+				example[0],
+				{Speed: SlowerSpeed, Voice: EnglishVoice, Text: "Hello there", Extra: map[string]string{"lesson": "1"}},
+			},
+		},
+		{
+			name:    "YAML with comments, nested values and a document marker",
+			decoder: func(raw string) *Decoder { return NewYAMLDecoder(strings.NewReader(raw)) },
+			raw: `# lessons
+---
+- speed: slower
+  voice: en
+  text: |
+    - not an item
+  lesson: 1
+
+-   voice: ja
+    text: "こんにちは~"`,
+			wantOpts: []Opt{
+				{Speed: SlowerSpeed, Voice: EnglishVoice, Text: "- not an item\n", Extra: map[string]string{"lesson": "1"}},
+				{Voice: JapaneseVoice, Text: "こんにちは~"},
+			},
+		},
+		{
+			name:    "empty CSV",
+			decoder: func(raw string) *Decoder { return NewCSVDecoder(strings.NewReader(raw)) },
+			wantErr: ErrEmptyCSV,
+		},
+		{
+			name:    "empty YAML",
+			decoder: func(raw string) *Decoder { return NewYAMLDecoder(strings.NewReader(raw)) },
+			raw:     "# nothing here\n",
+			wantErr: ErrEmptyYAML,
+		},
+		{
+			name:     "text too long stops at the row",
+			decoder:  func(raw string) *Decoder { return NewCSVDecoder(strings.NewReader(raw)) },
+			raw:      "speed,voice,text\nnormal,en,fine\nnormal,en," + tooLong + "\nnormal,en,never read",
+			wantOpts: []Opt{{Voice: EnglishVoice, Text: "fine"}},
+			wantErr:  ErrTextTooLong,
+		},
+		{
+			name:    "YAML that isn't a list",
+			decoder: func(raw string) *Decoder { return NewYAMLDecoder(strings.NewReader(raw)) },
+			raw:     "speed: normal\nvoice: en\n",
+			wantErr: errors.New(`row(1): line("speed: normal\n"): yaml rows must be the items of a top-level list`),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := tt.decoder(tt.raw)
+			opts := slices.Collect(d.All())
+			if diff := errdiff.Check(d.Err(), tt.wantErr); diff != "" {
+				t.Errorf("%T.Err(): err diff=\n%s", d, diff)
+			}
+			if diff := cmp.Diff(tt.wantOpts, opts); diff != "" {
+				t.Errorf("%T.All(): opts diff=\n%s", d, diff)
+			}
+		})
+	}
+}
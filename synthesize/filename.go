@@ -0,0 +1,188 @@
+package synthesize
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxSlugLength is the longest slug in bytes, it keeps filenames well below the 255 bytes most filesystems allow
+const maxSlugLength = 80
+
+// Filename returns a safe filename for the audio of an Opt such as "Hello-there_en_slower.mp3",
+// the text is turned into a slug that has no path separators or special characters,
+// and the voice and the speed tell apart the same text in different voices or speeds
+func Filename(opt Opt) string {
+	return fmt.Sprintf("%s_%s_%s.mp3", slug(opt.Text), slug(string(opt.Voice)), opt.Speed)
+}
+
+// slug keeps letters, digits and marks of text and joins them with dashes,
+// long text is truncated and gets a hash of the whole text so truncated slugs don't collide
+func slug(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) {
+			b.WriteRune(r)
+		} else if b.Len() > 0 && !strings.HasSuffix(b.String(), "-") {
+			b.WriteByte('-')
+		}
+	}
+	s := strings.TrimSuffix(b.String(), "-")
+
+	if len(s) <= maxSlugLength && s != "" {
+		return s
+	}
+	// cut at a rune boundary, the marks of the last letter may get lost but the hash keeps it unique
+	for len(s) > maxSlugLength {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	s = strings.TrimSuffix(s, "-")
+	if s == "" {
+		return textHash(text)
+	}
+	return s + "-" + textHash(text)
+}
+
+// uniqueFilename is Filename with a hash of the text,
+// which tells apart texts whose slugs are the same such as "Hello!" and "hello?"
+func uniqueFilename(opt Opt) string {
+	return fmt.Sprintf("%s-%s_%s_%s.mp3", slug(opt.Text), textHash(opt.Text), slug(string(opt.Voice)), opt.Speed)
+}
+
+// textHash returns a short hash of text
+func textHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:4])
+}
+
+// FilenameFunc returns the file that the audio of the Opt at index of a batch is saved to,
+// the file is relative to the output directory and a BatchRunner names files with Filename unless WithFilenameFunc is given
+type FilenameFunc func(index int, opt Opt) (string, error)
+
+// DefaultFilename is the FilenameFunc that uses Filename
+func DefaultFilename(_ int, opt Opt) (string, error) {
+	return Filename(opt), nil
+}
+
+// FilenameData is what a filename template is executed with
+type FilenameData struct {
+	// Index is the position of the Opt in the batch counted from 0
+	Index int
+	Voice Voice
+	Speed Speed
+	Text  string
+	// Slug is the text made safe for filenames, as used by Filename
+	Slug string
+	// Hash is a short hash of the text
+	Hash string
+	// Extra holds the extra columns of the row
+	Extra map[string]string
+}
+
+// ErrUnsafeFilename occurs when a filename is absolute or escapes the output directory
+var ErrUnsafeFilename = errors.New("unsafe filename")
+
+// FilenameTemplate parses a text/template such as "audio/{{.Voice}}/{{.Index}}-{{.Slug}}.mp3" into a FilenameFunc,
+// the template is executed with FilenameData and referring to a missing extra column is an error
+func FilenameTemplate(pattern string) (FilenameFunc, error) {
+	tmpl, err := template.New("filename").Option("missingkey=error").Parse(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%T.Parse(%q): %w", tmpl, pattern, err)
+	}
+
+	return func(index int, opt Opt) (string, error) {
+		data := FilenameData{
+			Index: index,
+			Voice: opt.Voice,
+			Speed: opt.Speed,
+			Text:  opt.Text,
+			Slug:  slug(opt.Text),
+			Hash:  textHash(opt.Text),
+			Extra: opt.Extra,
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			return "", fmt.Errorf("%T.Execute(%v): %w", tmpl, data, err)
+		}
+		return b.String(), nil
+	}, nil
+}
+
+// ErrDuplicateFilename occurs when several Opts of a batch would be saved to the same file,
+// filenames that only differ in case are the same since they are on case-insensitive file systems such as the ones of macOS and Windows
+var ErrDuplicateFilename = errors.New("duplicate filename")
+
+// filenameChecker names Opts one by one with Opt.File or a FilenameFunc,
+// it reports unsafe filenames and the Opts that would overwrite the file of an earlier Opt, rows are counted from 1.
+// Without a FilenameFunc the Opts are named with Filename, and with uniqueFilename if the name is already taken
+type filenameChecker struct {
+	fn FilenameFunc
+	mu sync.Mutex
+	// rows are keyed by the lowercase filename
+	rows map[string]int
+}
+
+func newFilenameChecker(fn FilenameFunc) *filenameChecker {
+	return &filenameChecker{fn: fn, rows: make(map[string]int)}
+}
+
+// name returns the filename of the Opt at index, it is safe for concurrent use
+func (c *filenameChecker) name(index int, opt Opt) (string, error) {
+	name, byDefault := opt.File, false
+	switch {
+	case name != "":
+	case c.fn == nil:
+		name, byDefault = Filename(opt), true
+	default:
+		var err error
+		if name, err = c.fn(index, opt); err != nil {
+			return "", fmt.Errorf("row(%d): %w", index+1, err)
+		}
+	}
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("row(%d) file(%s): %w", index+1, name, ErrUnsafeFilename)
+	}
+	name = filepath.Clean(name)
+
+	file, key := name, strings.ToLower(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.rows[key]; ok && byDefault {
+		name = uniqueFilename(opt)
+		key = strings.ToLower(name)
+	}
+	if row, ok := c.rows[key]; ok {
+		return "", fmt.Errorf("rows(%d, %d) file(%s): %w", row, index+1, file, ErrDuplicateFilename)
+	}
+	c.rows[key] = index + 1
+	if byDefault {
+		// the same text in the same voice and speed is still a duplicate
+		c.rows[strings.ToLower(uniqueFilename(opt))] = index + 1
+	}
+	return name, nil
+}
+
+// filenames returns the filenames of opts, it reports the problems of every Opt at once
+func filenames(opts []Opt, fn FilenameFunc) ([]string, error) {
+	c := newFilenameChecker(fn)
+	names := make([]string, len(opts))
+	var errs []error
+	for i, opt := range opts {
+		var err error
+		if names[i], err = c.name(i, opt); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
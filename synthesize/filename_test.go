@@ -0,0 +1,229 @@
+package synthesize
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mrwormhole/errdiff"
+)
+
+func TestFilename(t *testing.T) {
+	longText := strings.Repeat("hello ", 30)
+	tests := []struct {
+		name string
+		opt  Opt
+		want string
+	}{
+		{
+			name: "plain text",
+			opt:  Opt{Text: "Hello there", Voice: EnglishVoice, Speed: SlowerSpeed},
+			want: "Hello-there_en_slower.mp3",
+		},
+		{
+			name: "thai keeps its marks",
+			opt:  Opt{Text: "สวัสดีครับ", Voice: ThaiVoice},
+			want: "สวัสดีครับ_th_normal.mp3",
+		},
+		{
+			name: "path separators and punctuation",
+			opt:  Opt{Text: "../../etc/passwd? yes: no\nmaybe", Voice: EnglishVoice},
+			want: "etc-passwd-yes-no-maybe_en_normal.mp3",
+		},
+		{
+			name: "voice is sanitized",
+			opt:  Opt{Text: "hi", Voice: "../zh-TW", Speed: SlowestSpeed},
+			want: "hi_zh-TW_slowest.mp3",
+		},
+		{
+			name: "no letters",
+			opt:  Opt{Text: "?!", Voice: EnglishVoice},
+			want: textHash("?!") + "_en_normal.mp3",
+		},
+		{
+			name: "long text is truncated",
+			opt:  Opt{Text: longText, Voice: EnglishVoice},
+			want: strings.Repeat("hello-", 13) + "he-" + textHash(longText) + "_en_normal.mp3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Filename(tt.opt); got != tt.want {
+				t.Errorf("Filename(%v): got = %q, want = %q", tt.opt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilenameTemplate(t *testing.T) {
+	opt := Opt{
+		Text:  "Hello there",
+		Voice: EnglishVoice,
+		Speed: SlowerSpeed,
+		Extra: map[string]string{"lesson": "intro"},
+	}
+	tests := []struct {
+		name     string
+		pattern  string
+		opt      Opt
+		want     string
+		wantErr  error
+		parseErr bool
+	}{
+		{
+			name:    "layout",
+			pattern: "audio/{{.Voice}}/{{.Index}}-{{.Slug}}.mp3",
+			opt:     opt,
+			want:    "audio/en/7-Hello-there.mp3",
+		},
+		{
+			name:    "speed, hash and extra columns",
+			pattern: "{{.Extra.lesson}}/{{.Speed}}-{{.Hash}}.mp3",
+			opt:     opt,
+			want:    "intro/slower-" + textHash(opt.Text) + ".mp3",
+		},
+		{
+			name:    "missing extra column",
+			pattern: "{{.Extra.chapter}}.mp3",
+			opt:     opt,
+			wantErr: errors.New(`map has no entry for key "chapter"`),
+		},
+		{
+			name:     "invalid template",
+			pattern:  "{{.Slug",
+			parseErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn, err := FilenameTemplate(tt.pattern)
+			if (err != nil) != tt.parseErr {
+				t.Fatalf("FilenameTemplate(%q): got err = %v, want err = %v", tt.pattern, err, tt.parseErr)
+			}
+			if err != nil {
+				return
+			}
+
+			got, err := fn(7, tt.opt)
+			if diff := errdiff.Check(err, tt.wantErr); diff != "" {
+				t.Errorf("FilenameTemplate(%q)(%v): err diff=\n%s", tt.pattern, tt.opt, diff)
+			}
+			if got != tt.want {
+				t.Errorf("FilenameTemplate(%q)(%v): got = %q, want = %q", tt.pattern, tt.opt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilenames(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Opt
+		fn      FilenameFunc
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "same text in different voices and speeds",
+			opts: []Opt{
+				{Text: "hello", Voice: EnglishVoice},
+				{Text: "hello", Voice: EnglishVoice, Speed: SlowestSpeed},
+				{Text: "hello", Voice: GermanVoice},
+			},
+			fn:   DefaultFilename,
+			want: []string{"hello_en_normal.mp3", "hello_en_slowest.mp3", "hello_de_normal.mp3"},
+		},
+		{
+			name: "same slug of different texts",
+			opts: []Opt{
+				{Text: "hello", Voice: EnglishVoice},
+				{Text: "bye", Voice: EnglishVoice},
+				{Text: "hello?", Voice: EnglishVoice},
+				{Text: "Hello", Voice: EnglishVoice},
+			},
+			want: []string{
+				"hello_en_normal.mp3",
+				"bye_en_normal.mp3",
+				"hello-" + textHash("hello?") + "_en_normal.mp3",
+				"Hello-" + textHash("Hello") + "_en_normal.mp3",
+			},
+		},
+		{
+			name: "same text",
+			opts: []Opt{
+				{Text: "hello", Voice: EnglishVoice},
+				{Text: "bye", Voice: EnglishVoice},
+				{Text: "hello", Voice: EnglishVoice},
+			},
+			wantErr: errors.New("rows(1, 3) file(hello_en_normal.mp3): duplicate filename"),
+		},
+		{
+			name: "same file of a filename func",
+			opts: []Opt{
+				{Text: "hello", Voice: EnglishVoice},
+				{Text: "hello?", Voice: EnglishVoice},
+			},
+			fn:      DefaultFilename,
+			wantErr: errors.New("rows(1, 2) file(hello_en_normal.mp3): duplicate filename"),
+		},
+		{
+			name: "file of the opt wins",
+			opts: []Opt{
+				{Text: "hello", Voice: EnglishVoice, File: "greetings/hello.mp3"},
+				{Text: "bye", Voice: EnglishVoice},
+			},
+			fn:   DefaultFilename,
+			want: []string{"greetings/hello.mp3", "bye_en_normal.mp3"},
+		},
+		{
+			name: "file of the opt escaping the output directory",
+			opts: []Opt{
+				{Text: "hello", Voice: EnglishVoice, File: "/etc/hello.mp3"},
+			},
+			fn:      DefaultFilename,
+			wantErr: ErrUnsafeFilename,
+		},
+		{
+			name: "same file after cleaning",
+			opts: []Opt{
+				{Text: "hello", Voice: EnglishVoice},
+				{Text: "bye", Voice: EnglishVoice},
+			},
+			fn: func(index int, _ Opt) (string, error) {
+				return []string{"a/b.mp3", "a/./b.mp3"}[index], nil
+			},
+			wantErr: ErrDuplicateFilename,
+		},
+		{
+			name: "same file in another case",
+			opts: []Opt{
+				{Text: "Hello", Voice: EnglishVoice},
+				{Text: "hello", Voice: EnglishVoice},
+			},
+			fn:      DefaultFilename,
+			wantErr: errors.New("rows(1, 2) file(hello_en_normal.mp3): duplicate filename"),
+		},
+		{
+			name: "escaping the output directory",
+			opts: []Opt{
+				{Text: "hello", Voice: EnglishVoice},
+			},
+			fn: func(int, Opt) (string, error) {
+				return "../hello.mp3", nil
+			},
+			wantErr: ErrUnsafeFilename,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := filenames(tt.opts, tt.fn)
+			if diff := errdiff.Check(err, tt.wantErr); diff != "" {
+				t.Errorf("filenames(): err diff=\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("filenames(): diff=\n%s", diff)
+			}
+		})
+	}
+}
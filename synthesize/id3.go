@@ -0,0 +1,186 @@
+package synthesize
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+)
+
+// Tags is the ID3v2.4 metadata of an audio, empty fields are left out
+type Tags struct {
+	Title  string
+	Artist string
+	Album  string
+	// Track is the track number such as "3" or "3/12"
+	Track string
+	// Language is the ISO-639-2 code of the language spoken in the audio such as "eng"
+	Language string
+	Comment  string
+	// Cover is a JPEG or PNG image that is attached as the front cover
+	Cover []byte
+	// CoverMIME is the MIME type of Cover, it is detected from Cover if it is empty
+	CoverMIME string
+}
+
+// ID3 returns t as an ID3v2.4 tag with UTF-8 text frames
+func (t Tags) ID3() []byte {
+	var frames bytes.Buffer
+	for _, f := range []struct{ id, text string }{
+		{"TIT2", t.Title},
+		{"TPE1", t.Artist},
+		{"TALB", t.Album},
+		{"TRCK", t.Track},
+		{"TLAN", t.Language},
+	} {
+		if f.text != "" {
+			writeID3Frame(&frames, f.id, append([]byte{id3UTF8}, f.text...))
+		}
+	}
+	if t.Comment != "" {
+		// the language of the comment is unknown and its description is empty
+		body := append([]byte{id3UTF8}, "XXX\x00"...)
+		writeID3Frame(&frames, "COMM", append(body, t.Comment...))
+	}
+	if len(t.Cover) > 0 {
+		mime := t.CoverMIME
+		if mime == "" {
+			mime = http.DetectContentType(t.Cover)
+		}
+		body := append([]byte{id3UTF8}, mime...)
+		body = append(body, 0x00, id3FrontCover, 0x00) // end of the MIME type, picture type and empty description
+		writeID3Frame(&frames, "APIC", append(body, t.Cover...))
+	}
+
+	tag := make([]byte, 0, 10+frames.Len())
+	tag = append(tag, "ID3\x04\x00\x00"...)
+	tag = appendSyncsafe(tag, frames.Len())
+	return append(tag, frames.Bytes()...)
+}
+
+const (
+	id3UTF8       = 0x03
+	id3FrontCover = 0x03
+)
+
+// writeID3Frame writes a frame of an ID3v2.4 tag, whose size is syncsafe unlike in ID3v2.3
+func writeID3Frame(w *bytes.Buffer, id string, body []byte) {
+	w.WriteString(id)
+	w.Write(appendSyncsafe(nil, len(body)))
+	w.Write([]byte{0x00, 0x00}) // flags
+	w.Write(body)
+}
+
+// appendSyncsafe appends n as a 28 bit syncsafe integer, the inverse of syncsafe
+func appendSyncsafe(b []byte, n int) []byte {
+	return append(b, byte(n>>21&0x7F), byte(n>>14&0x7F), byte(n>>7&0x7F), byte(n&0x7F))
+}
+
+// TagMP3 returns audio with t as its ID3v2.4 tag, replacing the ID3v2 tag the audio already has
+func TagMP3(audio []byte, t Tags) []byte {
+	return append(t.ID3(), audio[id3v2Length(audio):]...)
+}
+
+// Tagging sets the ID3v2.4 tags of the audio of a batch, see WithTagging.
+// The title is the text, the language is the one of the voice, the comment is the speed and the track is the position in the batch,
+// and the title, album, artist and track extra columns of an Opt override the title, the track and the fields here.
+// The audio is tagged after it is cached, so the cache keeps the audio untagged
+type Tagging struct {
+	Album  string
+	Artist string
+	// Cover is a JPEG or PNG image that is attached as the front cover of every audio
+	Cover []byte
+}
+
+// Tags returns the tags of the Opt at index of a batch
+func (t Tagging) Tags(index int, opt Opt) Tags {
+	tags := Tags{
+		Title:    opt.Text,
+		Artist:   t.Artist,
+		Album:    t.Album,
+		Track:    strconv.Itoa(index + 1),
+		Language: voiceLanguages[opt.Voice],
+		Comment:  opt.Speed.String(),
+		Cover:    t.Cover,
+	}
+	for column, field := range map[string]*string{
+		"title":  &tags.Title,
+		"artist": &tags.Artist,
+		"album":  &tags.Album,
+		"track":  &tags.Track,
+	} {
+		if v := opt.Extra[column]; v != "" {
+			*field = v
+		}
+	}
+	return tags
+}
+
+// voiceLanguages are the ISO-639-2 codes of the languages of the voices, the variants of a language share its code
+// and Cantonese is Chinese since it has no code of its own
+var voiceLanguages = map[Voice]string{
+	AfrikaansVoice:           "afr",
+	AlbanianVoice:            "sqi",
+	AmharicVoice:             "amh",
+	ArabicVoice:              "ara",
+	BengaliVoice:             "ben",
+	BosnianVoice:             "bos",
+	BulgarianVoice:           "bul",
+	CantoneseVoice:           "zho",
+	CatalanVoice:             "cat",
+	ChineseSimplifiedVoice:   "zho",
+	ChineseTraditionalVoice:  "zho",
+	CroatianVoice:            "hrv",
+	CzechVoice:               "ces",
+	DanishVoice:              "dan",
+	DutchVoice:               "nld",
+	EnglishVoice:             "eng",
+	EstonianVoice:            "est",
+	FilipinoVoice:            "fil",
+	FinnishVoice:             "fin",
+	FrenchVoice:              "fra",
+	FrenchCanadianVoice:      "fra",
+	GalicianVoice:            "glg",
+	GermanVoice:              "deu",
+	GreekVoice:               "ell",
+	GujaratiVoice:            "guj",
+	HausaVoice:               "hau",
+	HebrewVoice:              "heb",
+	HindiVoice:               "hin",
+	HungarianVoice:           "hun",
+	IcelandicVoice:           "isl",
+	IndonesianVoice:          "ind",
+	ItalianVoice:             "ita",
+	JapaneseVoice:            "jpn",
+	JavaneseVoice:            "jav",
+	KhmerVoice:               "khm",
+	KoreanVoice:              "kor",
+	LatinVoice:               "lat",
+	LatvianVoice:             "lav",
+	LithuanianVoice:          "lit",
+	MalayVoice:               "msa",
+	MalayalamVoice:           "mal",
+	MarathiVoice:             "mar",
+	MyanmarVoice:             "mya",
+	NepaliVoice:              "nep",
+	NorwegianVoice:           "nor",
+	PolishVoice:              "pol",
+	PortugueseBrazilianVoice: "por",
+	PortugueseVoice:          "por",
+	PunjabiVoice:             "pan",
+	RomanianVoice:            "ron",
+	RussianVoice:             "rus",
+	SerbianVoice:             "srp",
+	SinhalaVoice:             "sin",
+	SlovakVoice:              "slk",
+	SpanishVoice:             "spa",
+	SundaneseVoice:           "sun",
+	SwahiliVoice:             "swa",
+	SwedishVoice:             "swe",
+	TamilVoice:               "tam",
+	TeluguVoice:              "tel",
+	ThaiVoice:                "tha",
+	UkrainianVoice:           "ukr",
+	UrduVoice:                "urd",
+	VietnameseVoice:          "vie",
+	WelshVoice:               "cym",
+}
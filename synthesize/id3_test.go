@@ -0,0 +1,162 @@
+package synthesize
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lingua-sensei/laverna/synthesize/synthesizetest"
+)
+
+// id3Frames decodes the frames of the ID3v2.4 tag at the start of b by their id
+func id3Frames(t *testing.T, b []byte) map[string]string {
+	t.Helper()
+	if !bytes.HasPrefix(b, []byte("ID3\x04\x00\x00")) {
+		t.Fatalf("id3Frames(): got header % x, want an ID3v2.4 header", b[:min(len(b), 6)])
+	}
+	tag := b[10 : 10+syncsafe(b[6:10])]
+	frames := make(map[string]string)
+	for len(tag) > 0 {
+		size := syncsafe(tag[4:8])
+		frames[string(tag[:4])] = string(tag[10 : 10+size])
+		tag = tag[10+size:]
+	}
+	return frames
+}
+
+func TestTags_ID3(t *testing.T) {
+	cover := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+	tests := []struct {
+		name string
+		tags Tags
+		want map[string]string
+	}{
+		{
+			name: "empty",
+			want: map[string]string{},
+		},
+		{
+			name: "every field",
+			tags: Tags{
+				Title:    "สวัสดีครับ",
+				Artist:   "laverna",
+				Album:    "lesson 1",
+				Track:    "3/12",
+				Language: "tha",
+				Comment:  "slower",
+				Cover:    cover,
+			},
+			want: map[string]string{
+				"TIT2": "\x03สวัสดีครับ",
+				"TPE1": "\x03laverna",
+				"TALB": "\x03lesson 1",
+				"TRCK": "\x033/12",
+				"TLAN": "\x03tha",
+				"COMM": "\x03XXX\x00slower",
+				"APIC": "\x03image/png\x00\x03\x00" + string(cover),
+			},
+		},
+		{
+			name: "cover MIME type",
+			tags: Tags{Cover: []byte("not an image"), CoverMIME: "image/jpeg"},
+			want: map[string]string{"APIC": "\x03image/jpeg\x00\x03\x00not an image"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, id3Frames(t, tt.tags.ID3())); diff != "" {
+				t.Errorf("%T.ID3(): frames diff=\n%s", tt.tags, diff)
+			}
+		})
+	}
+}
+
+func TestTagMP3(t *testing.T) {
+	audio := synthesizetest.Audio("hello", 0)
+	old := Tags{Title: "old", Comment: "a longer tag than the new one"}.ID3()
+	tags := Tags{Title: "hello"}
+
+	for _, in := range [][]byte{audio, slices.Concat(old, audio)} {
+		got := TagMP3(in, tags)
+		if want := slices.Concat(tags.ID3(), audio); !bytes.Equal(got, want) {
+			t.Errorf("TagMP3(): got %d bytes, want %d bytes of the new tag and the audio", len(got), len(want))
+		}
+		info, err := ParseMP3(got)
+		if err != nil || info.Frames != len(audio)/96 {
+			t.Errorf("ParseMP3(TagMP3()): got %d frames, %v, want %d frames", info.Frames, err, len(audio)/96)
+		}
+	}
+}
+
+func TestTagging_Tags(t *testing.T) {
+	tagging := Tagging{Album: "greetings", Artist: "laverna"}
+	tests := []struct {
+		name string
+		opt  Opt
+		want Tags
+	}{
+		{
+			name: "batch settings",
+			opt:  Opt{Text: "Hello there", Voice: EnglishVoice, Speed: SlowerSpeed},
+			want: Tags{Title: "Hello there", Artist: "laverna", Album: "greetings", Track: "3", Language: "eng", Comment: "slower"},
+		},
+		{
+			name: "extra columns",
+			opt: Opt{Text: "こんにちは", Voice: JapaneseVoice, Extra: map[string]string{
+				"title":  "Hello",
+				"artist": "teacher",
+				"album":  "lesson 2",
+				"track":  "1/5",
+				"note":   "ignored",
+			}},
+			want: Tags{Title: "Hello", Artist: "teacher", Album: "lesson 2", Track: "1/5", Language: "jpn", Comment: "normal"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, tagging.Tags(2, tt.opt)); diff != "" {
+				t.Errorf("%T.Tags(): diff=\n%s", tagging, diff)
+			}
+		})
+	}
+}
+
+func TestVoiceLanguages(t *testing.T) {
+	for _, v := range Voices() {
+		if lang := voiceLanguages[v]; len(lang) != 3 {
+			t.Errorf("voiceLanguages[%s]: got = %q, want an ISO-639-2 code", v, lang)
+		}
+	}
+}
+
+func TestBatchRunner_WithTagging(t *testing.T) {
+	saver := NewMemorySaver()
+	runner := NewBatchRunner(
+		WithSynthesizer(SynthesizerFunc(func(_ context.Context, opt Opt) ([]byte, error) {
+			return synthesizetest.Audio(opt.Text, 0), nil
+		})),
+		WithSaver(saver),
+		WithTagging(Tagging{Album: "greetings"}),
+	)
+	opts := []Opt{{Text: "hello", Voice: EnglishVoice}}
+	results, err := runner.RunResults(t.Context(), opts)
+	if err != nil {
+		t.Fatalf("%T.RunResults(): %v", runner, err)
+	}
+
+	audio, _ := saver.Get(Filename(opts[0]))
+	frames := id3Frames(t, audio)
+	if frames["TIT2"] != "\x03hello" || frames["TALB"] != "\x03greetings" || frames["TRCK"] != "\x031" {
+		t.Errorf("%T.RunResults(): got tag frames %q, want the title, album and track", runner, frames)
+	}
+	if got, want := results[0].Size, len(audio); got != want {
+		t.Errorf("%T.RunResults(): got size = %d, want the size of the tagged audio = %d", runner, got, want)
+	}
+	if got := results[0].Stream.Frames; got != len(synthesizetest.Audio("hello", 0))/96 {
+		t.Errorf("%T.RunResults(): got %d frames, want the tag to be skipped", runner, got)
+	}
+}
@@ -0,0 +1,69 @@
+package synthesize
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter limits how many requests are sent per second with a token bucket,
+// it is safe for concurrent use so one Limiter can be shared by several BatchRunners.
+// The chunks of long text are requests of their own when the synthesizer is a Client without a Limiter
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a Limiter that allows rate requests per second and bursts of up to burst requests,
+// a rate that is zero or negative allows every request
+func NewLimiter(rate float64, burst int) *Limiter {
+	burst = max(burst, 1)
+	return &Limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// Wait blocks until a request is allowed or ctx is done
+func (l *Limiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n requests are allowed or ctx is done
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || l.rate <= 0 {
+		return ctx.Err()
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.burst)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// give the reserved tokens back since they were never used
+		l.mu.Lock()
+		l.tokens += float64(n)
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
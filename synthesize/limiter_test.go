@@ -0,0 +1,149 @@
+package synthesize
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lingua-sensei/laverna/synthesize/synthesizetest"
+	"github.com/mrwormhole/errdiff"
+)
+
+func TestLimiter_Wait(t *testing.T) {
+	tests := []struct {
+		name    string
+		limiter *Limiter
+		waits   int
+		wantMin time.Duration
+		wantMax time.Duration
+	}{
+		{
+			name:    "nil limiter",
+			limiter: nil,
+			waits:   10,
+			wantMax: 20 * time.Millisecond,
+		},
+		{
+			name:    "unlimited",
+			limiter: NewLimiter(0, 1),
+			waits:   10,
+			wantMax: 20 * time.Millisecond,
+		},
+		{
+			name:    "burst",
+			limiter: NewLimiter(1, 5),
+			waits:   5,
+			wantMax: 20 * time.Millisecond,
+		},
+		{
+			name:    "rate",
+			limiter: NewLimiter(100, 1),
+			waits:   6,
+			wantMin: 50 * time.Millisecond,
+			wantMax: 500 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			for range tt.waits {
+				if err := tt.limiter.Wait(t.Context()); err != nil {
+					t.Fatalf("%T.Wait(): %v", tt.limiter, err)
+				}
+			}
+			elapsed := time.Since(start)
+			if elapsed < tt.wantMin || elapsed > tt.wantMax {
+				t.Errorf("%T.Wait(): %d waits took %v, want between %v and %v", tt.limiter, tt.waits, elapsed, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestLimiter_WaitCancelled(t *testing.T) {
+	l := NewLimiter(1, 1)
+	if err := l.Wait(t.Context()); err != nil {
+		t.Fatalf("%T.Wait(): %v", l, err)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	err := l.Wait(ctx)
+	if diff := errdiff.Check(err, context.DeadlineExceeded); diff != "" {
+		t.Errorf("%T.Wait(): err diff=\n%s", l, diff)
+	}
+	if l.tokens < -0.1 {
+		t.Errorf("%T.Wait(): got tokens = %v, want cancelled wait to give its token back", l, l.tokens)
+	}
+}
+
+func TestBatchRunner_WithLimiter(t *testing.T) {
+	const rate = 100
+	limiter := NewLimiter(rate, 1)
+	synthesizer := SynthesizerFunc(func(context.Context, Opt) ([]byte, error) {
+		return []byte("audio"), nil
+	})
+	opts := []Opt{
+		{Text: "test1", Voice: EnglishVoice},
+		{Text: "test2", Voice: EnglishVoice},
+		{Text: "test3", Voice: EnglishVoice},
+	}
+
+	// two runners share the limiter so their 6 requests are spread over at least 5 intervals
+	start := time.Now()
+	for range 2 {
+		runner := NewBatchRunner(
+			WithSynthesizer(synthesizer),
+			WithLimiter(limiter),
+			WithMaxWorkers(len(opts)),
+			WithSaveFunc(func(string, []byte) error { return nil }),
+		)
+		if err := runner.Run(t.Context(), opts); err != nil {
+			t.Fatalf("%T.Run(): %v", runner, err)
+		}
+	}
+	if elapsed, want := time.Since(start), 5*time.Second/rate; elapsed < want {
+		t.Errorf("%T.Run(): took %v, want at least %v", limiter, elapsed, want)
+	}
+}
+
+func TestBatchRunner_WithRateLimit_Chunks(t *testing.T) {
+	const rate = 50
+	server := synthesizetest.NewServer()
+	defer server.Close()
+
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+	transport := server.Client().Transport
+	client := &http.Client{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			times = append(times, time.Now())
+			mu.Unlock()
+			return transport.RoundTrip(req)
+		}),
+	}
+	runner := NewBatchRunner(
+		WithSynthesizer(&Client{HTTPClient: client, BaseURL: server.URL}),
+		WithRateLimit(rate, 1),
+		WithSaveFunc(func(string, []byte) error { return nil }),
+	)
+	// the text is sent in several chunks, each of them takes a token of its own
+	opt := Opt{Text: strings.Repeat("hello there ", 50), Voice: EnglishVoice}
+	chunks := len(Chunk(opt.Text, MaxTextLength))
+	if err := runner.Run(t.Context(), []Opt{opt}); err != nil {
+		t.Fatalf("%T.Run(): %v", runner, err)
+	}
+
+	if len(times) != chunks {
+		t.Fatalf("%T.Run(): got %d requests, want %d", runner, len(times), chunks)
+	}
+	// the chunks are spread over an interval each instead of being sent at once, allowing for the coarse timers of some platforms
+	if got, want := times[len(times)-1].Sub(times[0]), time.Duration(chunks-1)*time.Second/rate*3/4; got < want {
+		t.Errorf("%T.Run(): sent %d requests in %v, want at least %v", runner, chunks, got, want)
+	}
+}
@@ -0,0 +1,186 @@
+package synthesize
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// ManifestFormat is the file format of a Manifest
+type ManifestFormat int
+
+const (
+	// ManifestJSON writes a JSON array of ManifestEntry
+	ManifestJSON ManifestFormat = iota
+	// ManifestCSV writes a CSV row for every ManifestEntry, without the extra columns
+	ManifestCSV
+)
+
+// Statuses of a ManifestEntry
+const (
+	StatusOK      = "ok"
+	StatusCached  = "cached"
+	StatusSkipped = "skipped"
+	StatusFailed  = "failed"
+)
+
+// ManifestEntry describes the audio of an Opt of a batch
+type ManifestEntry struct {
+	// Index is the position of the Opt in the batch counted from 0
+	Index int    `json:"index"`
+	Voice Voice  `json:"voice"`
+	Speed string `json:"speed"`
+	Text  string `json:"text"`
+	// File is where the audio is saved relative to the output directory and Path is where it is saved as the Saver tells
+	File string `json:"file"`
+	Path string `json:"path"`
+	// Size is the size of the audio in bytes and SHA256 is its hex encoded hash, they are empty for skipped and failed Opts
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Duration is how long the audio plays in seconds and Frames is how many MP3 frames it has
+	Duration float64 `json:"duration"`
+	Frames   int     `json:"frames"`
+	Attempts int     `json:"attempts"`
+	// Status is one of StatusOK, StatusCached, StatusSkipped or StatusFailed
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Extra holds the extra columns of the row
+	Extra map[string]string `json:"extra,omitempty"`
+}
+
+// NewManifestEntry returns the ManifestEntry of a Result
+func NewManifestEntry(res Result) ManifestEntry {
+	entry := ManifestEntry{
+		Index:    res.Index,
+		Voice:    res.Opt.Voice,
+		Speed:    res.Opt.Speed.String(),
+		Text:     res.Opt.Text,
+		File:     res.File,
+		Path:     res.Path,
+		Size:     res.Size,
+		SHA256:   res.SHA256,
+		Duration: res.AudioDuration.Seconds(),
+		Frames:   res.Stream.Frames,
+		Attempts: res.Attempts,
+		Status:   StatusOK,
+		Extra:    res.Opt.Extra,
+	}
+	switch {
+	case res.Err != nil:
+		entry.Status = StatusFailed
+		entry.Error = res.Err.Error()
+	case res.Skipped:
+		entry.Status = StatusSkipped
+	case res.Cached:
+		entry.Status = StatusCached
+	}
+	return entry
+}
+
+// manifestColumns is the header of a CSV manifest
+var manifestColumns = []string{"index", "voice", "speed", "text", "file", "path", "size", "sha256", "duration", "frames", "attempts", "status", "error"}
+
+// record returns the CSV row of the entry
+func (e ManifestEntry) record() []string {
+	return []string{
+		strconv.Itoa(e.Index),
+		string(e.Voice),
+		e.Speed,
+		e.Text,
+		e.File,
+		e.Path,
+		strconv.Itoa(e.Size),
+		e.SHA256,
+		strconv.FormatFloat(e.Duration, 'f', 3, 64),
+		strconv.Itoa(e.Frames),
+		strconv.Itoa(e.Attempts),
+		e.Status,
+		e.Error,
+	}
+}
+
+// Manifest writes a ManifestEntry for every Opt of a batch as soon as it completes, so entries are in the order of completion,
+// it is safe for concurrent use and must be closed to finish the file, a BatchRunner never closes it
+type Manifest struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format ManifestFormat
+	csv    *csv.Writer
+	n      int
+}
+
+// NewManifest returns a Manifest that writes to w in format
+func NewManifest(w io.Writer, format ManifestFormat) *Manifest {
+	m := &Manifest{w: w, format: format}
+	if format == ManifestCSV {
+		m.csv = csv.NewWriter(w)
+	}
+	return m
+}
+
+// Write writes the ManifestEntry of res
+func (m *Manifest) Write(res Result) error {
+	entry := NewManifestEntry(res)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.format == ManifestCSV {
+		if m.n == 0 {
+			if err := m.csv.Write(manifestColumns); err != nil {
+				return fmt.Errorf("%T.Write(%v): %w", m.csv, manifestColumns, err)
+			}
+		}
+		m.n++
+		if err := m.csv.Write(entry.record()); err != nil {
+			return fmt.Errorf("%T.Write(%v): %w", m.csv, entry, err)
+		}
+		// flush every row so the manifest of an interrupted batch still lists what was done
+		m.csv.Flush()
+		if err := m.csv.Error(); err != nil {
+			return fmt.Errorf("%T.Flush(): %w", m.csv, err)
+		}
+		return nil
+	}
+
+	raw, err := json.MarshalIndent(entry, "  ", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent(%v): %w", entry, err)
+	}
+	prefix := ",\n  "
+	if m.n == 0 {
+		prefix = "[\n  "
+	}
+	m.n++
+	if _, err := io.WriteString(m.w, prefix+string(raw)); err != nil {
+		return fmt.Errorf("io.WriteString(): %w", err)
+	}
+	return nil
+}
+
+// Close finishes the file, it doesn't close the underlying writer
+func (m *Manifest) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.format == ManifestCSV {
+		if m.n == 0 {
+			if err := m.csv.Write(manifestColumns); err != nil {
+				return fmt.Errorf("%T.Write(%v): %w", m.csv, manifestColumns, err)
+			}
+		}
+		m.csv.Flush()
+		return m.csv.Error()
+	}
+
+	end := "\n]\n"
+	if m.n == 0 {
+		end = "[]\n"
+	}
+	if _, err := io.WriteString(m.w, end); err != nil {
+		return fmt.Errorf("io.WriteString(): %w", err)
+	}
+	return nil
+}
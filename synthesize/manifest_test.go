@@ -0,0 +1,169 @@
+package synthesize
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lingua-sensei/laverna/synthesize/synthesizetest"
+)
+
+func TestManifest(t *testing.T) {
+	synthErr := errors.New("synthesize error")
+	audio := synthesizetest.Audio("hello", 0)
+	sum := sha256.Sum256(audio)
+	opts := []Opt{
+		{Text: "hello", Voice: EnglishVoice, Extra: map[string]string{"lesson": "1"}},
+		{Text: "fail", Voice: ThaiVoice, Speed: SlowerSpeed},
+	}
+	run := func(format ManifestFormat) []byte {
+		var b bytes.Buffer
+		m := NewManifest(&b, format)
+		runner := NewBatchRunner(
+			WithContinueOnError(),
+			WithOutputDir("out"),
+			WithManifest(m),
+			WithSynthesizer(SynthesizerFunc(func(_ context.Context, opt Opt) ([]byte, error) {
+				if opt.Text == "fail" {
+					return nil, synthErr
+				}
+				return audio, nil
+			})),
+			WithSaveFunc(func(string, []byte) error { return nil }),
+		)
+		if err := runner.Run(t.Context(), opts); !errors.Is(err, synthErr) {
+			t.Fatalf("%T.Run(): got err = %v, want = %v", runner, err, synthErr)
+		}
+		if err := m.Close(); err != nil {
+			t.Fatalf("%T.Close(): %v", m, err)
+		}
+		return b.Bytes()
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		var got []ManifestEntry
+		if err := json.Unmarshal(run(ManifestJSON), &got); err != nil {
+			t.Fatalf("json.Unmarshal(): %v", err)
+		}
+		slices.SortFunc(got, func(a, b ManifestEntry) int { return a.Index - b.Index })
+		if len(got) == 2 {
+			got[1].Error = "" // the message is wrapped by the runner
+		}
+		want := []ManifestEntry{
+			{
+				Index:    0,
+				Voice:    EnglishVoice,
+				Speed:    "normal",
+				Text:     "hello",
+				File:     "hello_en_normal.mp3",
+				Path:     filepath.Join("out", "hello_en_normal.mp3"),
+				Size:     len(audio),
+				SHA256:   hex.EncodeToString(sum[:]),
+				Duration: 0.48,
+				Frames:   20,
+				Attempts: 1,
+				Status:   StatusOK,
+				Extra:    map[string]string{"lesson": "1"},
+			},
+			{
+				Index:    1,
+				Voice:    ThaiVoice,
+				Speed:    "slower",
+				Text:     "fail",
+				File:     "fail_th_slower.mp3",
+				Path:     filepath.Join("out", "fail_th_slower.mp3"),
+				Attempts: 1,
+				Status:   StatusFailed,
+			},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("manifest diff=\n%s", diff)
+		}
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		records, err := csv.NewReader(bytes.NewReader(run(ManifestCSV))).ReadAll()
+		if err != nil {
+			t.Fatalf("%T.ReadAll(): %v", csv.NewReader(nil), err)
+		}
+		if len(records) != 3 {
+			t.Fatalf("got %d records, want a header and %d rows", len(records), 2)
+		}
+		if diff := cmp.Diff(manifestColumns, records[0]); diff != "" {
+			t.Errorf("header diff=\n%s", diff)
+		}
+		var statuses []string
+		for _, record := range records[1:] {
+			statuses = append(statuses, record[11])
+		}
+		slices.Sort(statuses)
+		if diff := cmp.Diff([]string{StatusFailed, StatusOK}, statuses); diff != "" {
+			t.Errorf("statuses diff=\n%s", diff)
+		}
+	})
+}
+
+func TestNewManifestEntry(t *testing.T) {
+	tests := []struct {
+		name string
+		res  Result
+		want string
+	}{
+		{
+			name: "synthesized",
+			res:  Result{AudioDuration: 1500 * time.Millisecond},
+			want: StatusOK,
+		},
+		{
+			name: "cached",
+			res:  Result{Cached: true},
+			want: StatusCached,
+		},
+		{
+			name: "skipped",
+			res:  Result{Skipped: true},
+			want: StatusSkipped,
+		},
+		{
+			name: "failed",
+			res:  Result{Err: ErrNoAudio},
+			want: StatusFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewManifestEntry(tt.res).Status; got != tt.want {
+				t.Errorf("NewManifestEntry(): got status = %q, want = %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := NewManifestEntry(Result{AudioDuration: 1500 * time.Millisecond}).Duration; got != 1.5 {
+		t.Errorf("NewManifestEntry(): got duration = %v, want = %v", got, 1.5)
+	}
+}
+
+func TestManifest_Empty(t *testing.T) {
+	for format, want := range map[ManifestFormat]string{
+		ManifestJSON: "[]\n",
+		ManifestCSV:  "index,voice,speed,text,file,path,size,sha256,duration,frames,attempts,status,error\n",
+	} {
+		var b bytes.Buffer
+		if err := NewManifest(&b, format).Close(); err != nil {
+			t.Fatalf("%T.Close(): %v", &Manifest{}, err)
+		}
+		if got := b.String(); got != want {
+			t.Errorf("%T.Close(): got = %q, want = %q", &Manifest{}, got, want)
+		}
+	}
+}
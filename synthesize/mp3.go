@@ -0,0 +1,249 @@
+package synthesize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// frameHeader is a decoded MPEG audio frame header
+type frameHeader struct {
+	version    int // 1 for MPEG-1, 2 for MPEG-2, 25 for MPEG-2.5
+	layer      int
+	bitrate    int // bits per second
+	sampleRate int
+	padding    bool
+	mono       bool
+}
+
+var bitrates = map[[2]int][16]int{
+	{1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	{1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+	{1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	{2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	{2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+var sampleRates = map[int][3]int{
+	1:  {44100, 48000, 32000},
+	2:  {22050, 24000, 16000},
+	25: {11025, 12000, 8000},
+}
+
+// parseFrameHeader decodes the 4 byte header at the start of b, it tells what is wrong if b doesn't start with a valid header
+func parseFrameHeader(b []byte) (frameHeader, error) {
+	if len(b) < 4 {
+		return frameHeader{}, fmt.Errorf("header(% x) is cut short", b)
+	}
+	if b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return frameHeader{}, fmt.Errorf("sync word(%#x) must be 0x7ff", uint16(b[0])<<3|uint16(b[1]>>5))
+	}
+
+	var h frameHeader
+	switch (b[1] >> 3) & 0x03 {
+	case 0:
+		h.version = 25
+	case 2:
+		h.version = 2
+	case 3:
+		h.version = 1
+	default:
+		return frameHeader{}, errors.New("MPEG version is reserved")
+	}
+	h.layer = 4 - int((b[1]>>1)&0x03)
+	if h.layer == 4 {
+		return frameHeader{}, errors.New("layer is reserved")
+	}
+
+	bitrateIndex := int(b[2] >> 4)
+	if bitrateIndex == 0 || bitrateIndex == 15 {
+		return frameHeader{}, fmt.Errorf("bitrate index(%d) is free or bad", bitrateIndex)
+	}
+	table := h.version
+	if table == 25 {
+		table = 2
+	}
+	h.bitrate = bitrates[[2]int{table, h.layer}][bitrateIndex] * 1000
+
+	sampleRateIndex := int((b[2] >> 2) & 0x03)
+	if sampleRateIndex == 3 {
+		return frameHeader{}, errors.New("sample rate is reserved")
+	}
+	h.sampleRate = sampleRates[h.version][sampleRateIndex]
+	h.padding = b[2]&0x02 != 0
+	h.mono = b[3]>>6 == 3
+	return h, nil
+}
+
+// frameLength returns the size of the frame in bytes including its header
+func (h frameHeader) frameLength() int {
+	var padding int
+	if h.padding {
+		padding = 1
+	}
+
+	switch {
+	case h.layer == 1:
+		return (12*h.bitrate/h.sampleRate + padding) * 4
+	case h.layer == 3 && h.version != 1:
+		return 72*h.bitrate/h.sampleRate + padding
+	default:
+		return 144*h.bitrate/h.sampleRate + padding
+	}
+}
+
+// sideInfoLength returns the size of the layer III side information that follows the header
+func (h frameHeader) sideInfoLength() int {
+	switch {
+	case h.version == 1 && h.mono:
+		return 17
+	case h.version == 1:
+		return 32
+	case h.mono:
+		return 9
+	default:
+		return 17
+	}
+}
+
+// isInfoFrame reports whether the frame carries a Xing, Info or VBRI header instead of audio
+func isInfoFrame(h frameHeader, frame []byte) bool {
+	if h.layer == 3 {
+		offset := 4 + h.sideInfoLength()
+		if len(frame) >= offset+4 {
+			tag := frame[offset : offset+4]
+			if bytes.Equal(tag, []byte("Xing")) || bytes.Equal(tag, []byte("Info")) {
+				return true
+			}
+		}
+	}
+	const vbriOffset = 4 + 32
+	return len(frame) >= vbriOffset+4 && bytes.Equal(frame[vbriOffset:vbriOffset+4], []byte("VBRI"))
+}
+
+// skipID3 returns b without its leading ID3v2 tag and trailing ID3v1 tag
+func skipID3(b []byte) []byte {
+	b = b[id3v2Length(b):]
+	if len(b) >= 128 && bytes.HasPrefix(b[len(b)-128:], []byte("TAG")) {
+		b = b[:len(b)-128]
+	}
+	return b
+}
+
+// id3v2Length returns the size of the ID3v2 tag at the start of b, it is 0 if b doesn't start with a tag
+func id3v2Length(b []byte) int {
+	if len(b) < 10 || !bytes.HasPrefix(b, []byte("ID3")) {
+		return 0
+	}
+	size := 10 + syncsafe(b[6:10])
+	if b[5]&0x10 != 0 { // footer present
+		size += 10
+	}
+	return min(size, len(b))
+}
+
+// syncsafe decodes a 28 bit syncsafe integer used by ID3v2 sizes
+func syncsafe(b []byte) int {
+	v := binary.BigEndian.Uint32(b)
+	return int(v&0x7F | (v>>8&0x7F)<<7 | (v>>16&0x7F)<<14 | (v>>24&0x7F)<<21)
+}
+
+// joinMP3 stitches MP3 streams into a single stream by concatenating their audio frames,
+// tags and Xing/Info/VBRI frames are dropped since their frame counts would no longer be correct
+func joinMP3(parts [][]byte) []byte {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+
+	var out []byte
+	for _, part := range parts {
+		part = skipID3(part)
+		if h, err := parseFrameHeader(part); err == nil {
+			if n := h.frameLength(); n <= len(part) && isInfoFrame(h, part[:n]) {
+				part = part[n:]
+			}
+		}
+		out = append(out, part...)
+	}
+	return out
+}
+
+// ErrInvalidAudio occurs when audio isn't a plausible MP3 stream
+var ErrInvalidAudio = errors.New("invalid mp3 audio")
+
+// StreamInfo describes an MP3 stream
+type StreamInfo struct {
+	// Version is 1 for MPEG-1, 2 for MPEG-2 and 25 for MPEG-2.5
+	Version int
+	Layer   int
+	// Bitrate is the average bitrate of the audio frames in bits per second
+	Bitrate    int
+	SampleRate int
+	Channels   int
+	// Frames is the number of audio frames, which doesn't count a Xing, Info or VBRI frame
+	Frames int
+	// Duration is how long the audio frames play, it is exact for constant and variable bitrate streams alike
+	Duration time.Duration
+}
+
+// ParseMP3 walks the frames of an MP3 stream and describes it, ID3v2 and ID3v1 tags and a Xing, Info or VBRI frame are skipped,
+// it fails with ErrInvalidAudio telling what is wrong unless b is optional ID3 tags followed by MPEG audio frames
+// where the last frame isn't cut short, data that isn't a frame after the last frame is ignored
+func ParseMP3(b []byte) (StreamInfo, error) {
+	b = skipID3(b)
+	var (
+		info    StreamInfo
+		size    int
+		samples int64
+	)
+	for offset, frame := 0, 0; offset < len(b); frame++ {
+		h, err := parseFrameHeader(b[offset:])
+		if err != nil && (frame == 0 || len(b)-offset < 4) {
+			return StreamInfo{}, fmt.Errorf("frame(%d) offset(%d): %v: %w", frame, offset, err, ErrInvalidAudio)
+		}
+		if err != nil { // trailing data that isn't audio
+			break
+		}
+		n := h.frameLength()
+		if offset+n > len(b) {
+			return StreamInfo{}, fmt.Errorf("frame(%d) offset(%d): frame of %d bytes is cut short at %d bytes: %w",
+				frame, offset, n, len(b)-offset, ErrInvalidAudio)
+		}
+		if frame == 0 {
+			info.Version = h.version
+			info.Layer = h.layer
+			info.SampleRate = h.sampleRate
+			info.Channels = 2
+			if h.mono {
+				info.Channels = 1
+			}
+		}
+		if frame > 0 || !isInfoFrame(h, b[offset:offset+n]) {
+			info.Frames++
+			size += n
+			samples += int64(samplesPerFrame(h.version, h.layer))
+		}
+		offset += n
+	}
+	if info.Frames == 0 {
+		return StreamInfo{}, fmt.Errorf("no audio frames after the tags: %w", ErrInvalidAudio)
+	}
+	info.Bitrate = int(int64(size) * 8 * int64(info.SampleRate) / samples)
+	info.Duration = time.Duration(samples) * time.Second / time.Duration(info.SampleRate)
+	return info, nil
+}
+
+// samplesPerFrame returns how many samples every frame of the version and the layer holds
+func samplesPerFrame(version, layer int) int {
+	switch {
+	case layer == 1:
+		return 384
+	case layer == 3 && version != 1:
+		return 576
+	default:
+		return 1152
+	}
+}
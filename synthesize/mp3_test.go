@@ -0,0 +1,191 @@
+package synthesize
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mrwormhole/errdiff"
+)
+
+// testFrame builds a MPEG-2 layer III mono frame at 32kbps and 24kHz, the format upstream returns
+func testFrame(tag string) []byte {
+	frame := make([]byte, 96)
+	copy(frame, []byte{0xFF, 0xF3, 0x44, 0xC4})
+	copy(frame[4+9:], tag)
+	return frame
+}
+
+func TestParseFrameHeader(t *testing.T) {
+	h, err := parseFrameHeader(testFrame(""))
+	if err != nil {
+		t.Fatalf("parseFrameHeader(): %v", err)
+	}
+	want := frameHeader{version: 2, layer: 3, bitrate: 32000, sampleRate: 24000, mono: true}
+	if h != want {
+		t.Errorf("parseFrameHeader(): got = %+v, want = %+v", h, want)
+	}
+	if got := h.frameLength(); got != 96 {
+		t.Errorf("%T.frameLength(): got = %d, want = %d", h, got, 96)
+	}
+
+	for _, b := range [][]byte{[]byte("ID3\x04"), {0xFF, 0xEB, 0x44, 0xC4}, {0xFF, 0xF3, 0x04, 0xC4}, {0xFF, 0xF3, 0x4C, 0xC4}, {0xFF, 0xF3}} {
+		if _, err := parseFrameHeader(b); err == nil {
+			t.Errorf("parseFrameHeader(% x): got err = nil, want an error", b)
+		}
+	}
+}
+
+func TestJoinMP3(t *testing.T) {
+	frame := testFrame("")
+	info := testFrame("Info")
+	id3 := []byte("ID3\x04\x00\x00\x00\x00\x00\x02ab")
+
+	tests := []struct {
+		name  string
+		parts [][]byte
+		want  []byte
+	}{
+		{
+			name:  "single part is untouched",
+			parts: [][]byte{slices.Concat(id3, info, frame)},
+			want:  slices.Concat(id3, info, frame),
+		},
+		{
+			name:  "plain frames",
+			parts: [][]byte{frame, slices.Concat(frame, frame)},
+			want:  slices.Concat(frame, frame, frame),
+		},
+		{
+			name:  "tags and info frames are dropped",
+			parts: [][]byte{slices.Concat(id3, info, frame), slices.Concat(info, frame)},
+			want:  slices.Concat(frame, frame),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := joinMP3(tt.parts); !bytes.Equal(got, tt.want) {
+				t.Errorf("joinMP3(): got %d bytes, want %d bytes", len(got), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestParseMP3_Duration(t *testing.T) {
+	id3 := []byte("ID3\x04\x00\x00\x00\x00\x00\x02ab")
+	// a MPEG-2 layer III mono frame at 64kbps and 24kHz, which is twice the size of testFrame and plays as long
+	fast := make([]byte, 192)
+	copy(fast, []byte{0xFF, 0xF3, 0x84, 0xC4})
+	// a MPEG-1 layer III stereo frame at 128kbps and 44.1kHz
+	stereo := make([]byte, 417)
+	copy(stereo, []byte{0xFF, 0xFB, 0x90, 0x64})
+	vbri := slices.Clone(stereo)
+	copy(vbri[36:], "VBRI")
+
+	tests := []struct {
+		name       string
+		audio      []byte
+		wantFrames int
+		want       time.Duration
+	}{
+		{
+			name:       "constant bitrate",
+			audio:      bytes.Repeat(testFrame(""), 10),
+			wantFrames: 10,
+			want:       240 * time.Millisecond,
+		},
+		{
+			name:       "tags and Xing frame are skipped",
+			audio:      slices.Concat(id3, testFrame("Xing"), testFrame("")),
+			wantFrames: 1,
+			want:       24 * time.Millisecond,
+		},
+		{
+			name:       "variable bitrate",
+			audio:      slices.Concat(testFrame(""), fast, fast, testFrame("")),
+			wantFrames: 4,
+			want:       96 * time.Millisecond,
+		},
+		{
+			name:       "VBRI frame is skipped",
+			audio:      slices.Concat(vbri, stereo, stereo, stereo),
+			wantFrames: 3,
+			want:       3 * 1152 * time.Second / 44100,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMP3(tt.audio)
+			if err != nil {
+				t.Fatalf("ParseMP3(): %v", err)
+			}
+			if got.Frames != tt.wantFrames || got.Duration != tt.want {
+				t.Errorf("ParseMP3(): got %d frames of %v, want %d frames of %v", got.Frames, got.Duration, tt.wantFrames, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMP3(t *testing.T) {
+	frame := testFrame("")
+	id3 := []byte("ID3\x04\x00\x00\x00\x00\x00\x02ab")
+	id3v1 := append([]byte("TAG"), make([]byte, 125)...)
+	info := StreamInfo{Version: 2, Layer: 3, Bitrate: 32000, SampleRate: 24000, Channels: 1, Frames: 2, Duration: 48 * time.Millisecond}
+	tests := []struct {
+		name    string
+		audio   []byte
+		want    StreamInfo
+		wantErr error
+	}{
+		{
+			name:  "frames",
+			audio: slices.Concat(frame, frame),
+			want:  info,
+		},
+		{
+			name:  "tags and info frame",
+			audio: slices.Concat(id3, testFrame("Info"), frame, frame, id3v1),
+			want:  info,
+		},
+		{
+			name:    "cut short",
+			audio:   slices.Concat(frame, frame[:50]),
+			wantErr: errors.New("frame(1) offset(96): frame of 96 bytes is cut short at 50 bytes: invalid mp3 audio"),
+		},
+		{
+			name:    "cut in a header",
+			audio:   slices.Concat(frame, frame[:2]),
+			wantErr: errors.New("frame(1) offset(96): header(ff f3) is cut short: invalid mp3 audio"),
+		},
+		{
+			name:    "not MP3",
+			audio:   []byte("<html>not an mp3</html>"),
+			wantErr: errors.New("frame(0) offset(0): sync word(0x1e3) must be 0x7ff: invalid mp3 audio"),
+		},
+		{
+			name:    "tags only",
+			audio:   id3,
+			wantErr: errors.New("no audio frames after the tags: invalid mp3 audio"),
+		},
+		{
+			name:    "empty",
+			wantErr: ErrInvalidAudio,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMP3(tt.audio)
+			if diff := errdiff.Check(err, tt.wantErr); diff != "" {
+				t.Errorf("ParseMP3(): err diff=\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ParseMP3(): diff=\n%s", diff)
+			}
+		})
+	}
+}
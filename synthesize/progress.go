@@ -0,0 +1,117 @@
+package synthesize
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// EventKind is what happened to an Opt of a batch
+type EventKind int
+
+const (
+	// EventQueued is sent when an Opt is taken into the batch and waits for a worker
+	EventQueued EventKind = iota
+	// EventStarted is sent when a worker starts on an Opt
+	EventStarted
+	// EventRetried is sent when an attempt of an Opt failed and it is going to be tried again
+	EventRetried
+	// EventSucceeded is sent when the audio of an Opt is saved, skipped or taken from the cache
+	EventSucceeded
+	// EventFailed is sent when an Opt failed
+	EventFailed
+)
+
+var eventKinds = []string{"queued", "started", "retried", "succeeded", "failed"}
+
+// String returns the string representation of the event kind, unknown kinds are shown as "EventKind(9)"
+func (k EventKind) String() string {
+	if k < 0 || int(k) >= len(eventKinds) {
+		return "EventKind(" + strconv.Itoa(int(k)) + ")"
+	}
+	return eventKinds[k]
+}
+
+// Progress counts the Opts of a batch
+type Progress struct {
+	// Total is the number of Opts in the batch, it is 0 if it isn't known such as for RunSeq
+	Total int
+	// Queued, Running, Succeeded and Failed are the number of Opts in each state, so their sum is the number of Opts seen so far
+	Queued    int
+	Running   int
+	Succeeded int
+	Failed    int
+	// Retries is the number of attempts that are retried
+	Retries int
+	// Elapsed is the time since the batch started
+	Elapsed time.Duration
+	// ETA is the estimated time until the batch finishes, it is 0 until an Opt completes or if Total isn't known
+	ETA time.Duration
+}
+
+// Done returns the number of Opts that completed
+func (p Progress) Done() int {
+	return p.Succeeded + p.Failed
+}
+
+// Event tells what happened to an Opt of a batch along with the progress of the batch,
+// the func given to WithProgress gets one Event at a time and should return quickly since the workers wait for it
+type Event struct {
+	Kind  EventKind
+	Index int
+	Opt   Opt
+	// Attempt is the number of the attempt that failed for EventRetried
+	Attempt int
+	// Err is why the attempt or the Opt failed for EventRetried and EventFailed
+	Err      error
+	Progress Progress
+}
+
+// progressTracker counts the Opts of a batch and sends an Event for every change to fn,
+// fn is called by one goroutine at a time, a nil progressTracker does nothing
+type progressTracker struct {
+	fn    func(Event)
+	start time.Time
+
+	mu sync.Mutex
+	p  Progress
+}
+
+func newProgressTracker(fn func(Event), total int) *progressTracker {
+	if fn == nil {
+		return nil
+	}
+	return &progressTracker{fn: fn, start: time.Now(), p: Progress{Total: max(total, 0)}}
+}
+
+// emit counts an event and sends it
+func (t *progressTracker) emit(e Event) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch e.Kind {
+	case EventQueued:
+		t.p.Queued++
+	case EventStarted:
+		t.p.Queued--
+		t.p.Running++
+	case EventRetried:
+		t.p.Retries++
+	case EventSucceeded:
+		t.p.Running--
+		t.p.Succeeded++
+	case EventFailed:
+		t.p.Running--
+		t.p.Failed++
+	}
+	t.p.Elapsed = time.Since(t.start)
+	if done := t.p.Done(); done > 0 && t.p.Total > 0 {
+		t.p.ETA = t.p.Elapsed / time.Duration(done) * time.Duration(max(t.p.Total-done, 0))
+	}
+
+	e.Progress = t.p
+	t.fn(e)
+}
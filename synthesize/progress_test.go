@@ -0,0 +1,88 @@
+package synthesize
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/lingua-sensei/laverna/synthesize/synthesizetest"
+)
+
+func TestBatchRunner_WithProgress(t *testing.T) {
+	server := synthesizetest.NewServer(synthesizetest.WithFaults(synthesizetest.Unavailable))
+	defer server.Close()
+
+	var events []Event
+	runner := NewBatchRunner(
+		WithClient(server.Client()),
+		WithMaxWorkers(1),
+		WithContinueOnError(),
+		WithRetry(RetryPolicy{MaxAttempts: 2}),
+		WithSaveFunc(func(string, []byte) error { return nil }),
+		WithProgress(func(e Event) { events = append(events, e) }),
+	)
+	opts := []Opt{
+		{Text: "retried", Voice: EnglishVoice},
+		{Text: "fine", Voice: EnglishVoice},
+		{Text: "failed", Voice: "xx"},
+	}
+	if err := runner.Run(t.Context(), opts); err == nil {
+		t.Fatalf("%T.Run(): got no error, want the unknown voice to fail", runner)
+	}
+
+	kinds := make(map[EventKind]int)
+	for _, e := range events {
+		kinds[e.Kind]++
+		if e.Kind == EventRetried && (e.Index != 0 || e.Attempt != 1 || e.Err == nil) {
+			t.Errorf("retried event: got index = %d, attempt = %d, err = %v, want the first attempt of the first opt", e.Index, e.Attempt, e.Err)
+		}
+	}
+	wantKinds := map[EventKind]int{EventQueued: 3, EventStarted: 3, EventRetried: 1, EventSucceeded: 2, EventFailed: 1}
+	if diff := cmp.Diff(wantKinds, kinds); diff != "" {
+		t.Errorf("event kinds diff=\n%s", diff)
+	}
+
+	last := events[len(events)-1].Progress
+	want := Progress{Total: 3, Succeeded: 2, Failed: 1, Retries: 1}
+	if diff := cmp.Diff(want, last, cmpopts.IgnoreFields(Progress{}, "Elapsed")); diff != "" {
+		t.Errorf("last progress diff=\n%s", diff)
+	}
+}
+
+func TestProgressTracker_ETA(t *testing.T) {
+	var got Progress
+	tracker := newProgressTracker(func(e Event) { got = e.Progress }, 4)
+	tracker.start = time.Now().Add(-time.Second)
+	for _, kind := range []EventKind{EventQueued, EventStarted, EventSucceeded} {
+		tracker.emit(Event{Kind: kind})
+	}
+	// 1 of 4 done in a second leaves 3 more seconds
+	if got.ETA < 3*time.Second || got.ETA > 4*time.Second {
+		t.Errorf("%T.emit(): got ETA = %v, want about %v", tracker, got.ETA, 3*time.Second)
+	}
+
+	var nilTracker *progressTracker
+	nilTracker.emit(Event{Kind: EventQueued}) // must not panic
+	if newProgressTracker(nil, 1) != nil {
+		t.Errorf("newProgressTracker(nil): want a nil tracker")
+	}
+}
+
+func TestEventKind_String(t *testing.T) {
+	tests := []struct {
+		kind EventKind
+		want string
+	}{
+		{kind: EventQueued, want: "queued"},
+		{kind: EventFailed, want: "failed"},
+		{kind: 9, want: "EventKind(9)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.kind.String(); got != tt.want {
+				t.Errorf("%T.String(): got = %v, want = %v", tt.kind, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package synthesize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy configures how an Opt is retried when producing its audio fails with a transient error,
+// a BatchRunner doesn't retry Opts unless WithRetry is given
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one, values below 2 disable retries
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, it doubles on every retry
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between retries, zero means no cap
+	MaxDelay time.Duration
+	// Jitter is the fraction of the delay in [0, 1] that is randomized to spread retries of concurrent workers
+	Jitter float64
+}
+
+// DefaultRetryPolicy retries an Opt twice with a randomized delay that starts at half a second
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+	Jitter:      0.5,
+}
+
+// delay returns how long to wait after the given attempt failed with err, attempts are counted from 1.
+// The upstream asking to wait longer with Retry-After is honoured up to MaxDelay.
+func (p RetryPolicy) delay(attempt int, err error) time.Duration {
+	d := p.BaseDelay
+	for range attempt - 1 {
+		if p.MaxDelay > 0 && d >= p.MaxDelay {
+			break
+		}
+		d *= 2
+	}
+	if p.MaxDelay > 0 {
+		d = min(d, p.MaxDelay)
+	}
+
+	jitter := min(max(p.Jitter, 0), 1)
+	d -= time.Duration(rand.Float64() * jitter * float64(d))
+
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) && upstreamErr.RetryAfter > d {
+		d = upstreamErr.RetryAfter
+		if p.MaxDelay > 0 {
+			d = min(d, p.MaxDelay)
+		}
+	}
+	return d
+}
+
+// do calls fn until it succeeds, fails with an error that isn't retryable or runs out of attempts,
+// it returns the number of attempts it made and calls onRetry, if it isn't nil, with every attempt that is retried
+func (p RetryPolicy) do(ctx context.Context, fn func(context.Context) error, onRetry func(attempt int, err error)) (int, error) {
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt >= p.MaxAttempts || !IsRetryable(err) {
+			if err != nil && attempt > 1 {
+				err = fmt.Errorf("attempt(%d): %w", attempt, err)
+			}
+			return attempt, err
+		}
+		if onRetry != nil {
+			onRetry(attempt, err)
+		}
+
+		timer := time.NewTimer(p.delay(attempt, err))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, fmt.Errorf("attempt(%d): %w: %w", attempt, ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// IsRetryable reports whether err is transient, so trying again may succeed.
+// Timeouts, connection resets, HTTP 429 and 5xx statuses and responses without audio are retryable,
+// cancellations and invalid options such as ErrTextTooLong, ErrUnknownVoice and ErrUnknownSpeed are not.
+func IsRetryable(err error) bool {
+	switch {
+	case err == nil,
+		errors.Is(err, context.Canceled),
+		errors.Is(err, ErrTextTooLong),
+		errors.Is(err, ErrUnknownVoice),
+		errors.Is(err, ErrUnknownSpeed):
+		return false
+	case errors.Is(err, ErrNoAudio),
+		errors.Is(err, ErrRateLimited),
+		errors.Is(err, ErrUnavailable),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.EPIPE):
+		return true
+	}
+
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.StatusCode == http.StatusRequestTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout()
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package synthesize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lingua-sensei/laverna/synthesize/synthesizetest"
+	"github.com/mrwormhole/errdiff"
+)
+
+func TestRetryPolicy_delay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: 100 * time.Millisecond},
+		{attempt: 2, want: 200 * time.Millisecond},
+		{attempt: 3, want: 400 * time.Millisecond},
+		{attempt: 4, want: 800 * time.Millisecond},
+		{attempt: 5, want: time.Second},
+		{attempt: 100, want: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.attempt), func(t *testing.T) {
+			if got := p.delay(tt.attempt, nil); got != tt.want {
+				t.Errorf("%T.delay(%d): got = %v, want = %v", p, tt.attempt, got, tt.want)
+			}
+		})
+	}
+
+	retryAfter := &UpstreamError{StatusCode: http.StatusTooManyRequests, RetryAfter: 700 * time.Millisecond}
+	if got, want := p.delay(1, retryAfter), 700*time.Millisecond; got != want {
+		t.Errorf("%T.delay(1, %v): got = %v, want = %v", p, retryAfter, got, want)
+	}
+	retryAfter.RetryAfter = time.Minute
+	if got, want := p.delay(1, retryAfter), time.Second; got != want {
+		t.Errorf("%T.delay(1, %v): got = %v, want = %v", p, retryAfter, got, want)
+	}
+
+	p.Jitter = 0.5
+	for range 100 {
+		if got := p.delay(3, nil); got <= 200*time.Millisecond || got > 400*time.Millisecond {
+			t.Fatalf("%T.delay(3): got = %v, want in (200ms, 400ms]", p, got)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "canceled", err: fmt.Errorf("wrap: %w", context.Canceled), want: false},
+		{name: "text too long", err: fmt.Errorf("wrap: %w", ErrTextTooLong), want: false},
+		{name: "unknown voice", err: fmt.Errorf("wrap: %w", ErrUnknownVoice), want: false},
+		{name: "unknown speed", err: fmt.Errorf("wrap: %w", ErrUnknownSpeed), want: false},
+		{name: "no audio", err: fmt.Errorf("wrap: %w", ErrNoAudio), want: true},
+		{name: "unexpected EOF", err: fmt.Errorf("wrap: %w", io.ErrUnexpectedEOF), want: true},
+		{name: "connection reset", err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}, want: true},
+		{name: "timeout", err: &net.DNSError{IsTimeout: true}, want: true},
+		{name: "not found host", err: &net.DNSError{IsNotFound: true}, want: false},
+		{name: "too many requests", err: &UpstreamError{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "service unavailable", err: &UpstreamError{StatusCode: http.StatusServiceUnavailable}, want: true},
+		{name: "request timeout", err: &UpstreamError{StatusCode: http.StatusRequestTimeout}, want: true},
+		{name: "bad request", err: &UpstreamError{StatusCode: http.StatusBadRequest}, want: false},
+		{name: "unknown", err: errors.New("unknown"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v): got = %v, want = %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatchRunner_WithRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+	tests := []struct {
+		name         string
+		faults       []synthesizetest.Fault
+		opt          Opt
+		wantErr      error
+		wantRequests int
+	}{
+		{
+			name:         "recovers from transient faults",
+			faults:       []synthesizetest.Fault{synthesizetest.RateLimited, synthesizetest.Unavailable},
+			opt:          Opt{Text: "hello", Voice: EnglishVoice},
+			wantRequests: 3,
+		},
+		{
+			name: "runs out of attempts",
+			faults: []synthesizetest.Fault{
+				synthesizetest.TruncatedBody,
+				synthesizetest.ErrorEnvelope,
+				synthesizetest.MalformedBody,
+			},
+			opt:          Opt{Text: "hello", Voice: EnglishVoice},
+			wantErr:      ErrNoAudio,
+			wantRequests: 3,
+		},
+		{
+			name:         "permanent error",
+			opt:          Opt{Text: "hello", Voice: "xx"},
+			wantErr:      ErrUnknownVoice,
+			wantRequests: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := synthesizetest.NewServer(synthesizetest.WithFaults(tt.faults...))
+			defer server.Close()
+
+			runner := NewBatchRunner(
+				WithSynthesizer(&Client{BaseURL: server.URL}),
+				WithRetry(policy),
+				WithSaveFunc(func(string, []byte) error { return nil }),
+			)
+			err := runner.Run(t.Context(), []Opt{tt.opt})
+			if diff := errdiff.Check(err, tt.wantErr); diff != "" {
+				t.Errorf("%T.Run(): err diff=\n%s", runner, diff)
+			}
+			if got := len(server.Requests()); got != tt.wantRequests {
+				t.Errorf("%T.Run(): got %d requests, want %d requests", runner, got, tt.wantRequests)
+			}
+		})
+	}
+}
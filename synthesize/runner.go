@@ -1,20 +1,50 @@
 package synthesize
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"iter"
+	"log/slog"
 	"net/http"
-	"os"
+	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/sourcegraph/conc/pool"
 )
 
-// BatchRunner handles concurrent processing of synthesize operations
+// BatchRunner handles concurrent processing of synthesize operations,
+// its logger logs retries at info level, failures at warn level and the rest at debug level and the default Client logs to it too
 type BatchRunner struct {
-	client     *http.Client
-	maxWorkers int
-	saveFn     func(string, []byte) error
+	client       *http.Client
+	synthesizer  Synthesizer
+	maxWorkers   int
+	retry        RetryPolicy
+	limiter      *Limiter
+	filename     FilenameFunc
+	outDir       string
+	saver        Saver
+	existsFn     func(string, Opt) (bool, error)
+	skipExisting bool
+	state        *State
+	cache        *Cache
+	tagging      *Tagging
+	manifest     *Manifest
+	progress     func(Event)
+	logger       *slog.Logger
+	keepGoing    bool
+
+	adaptive    bool
+	adaptiveMin int
+	concurrency atomic.Int64
 }
 
 // NewBatchRunner creates a new BatchRunner with the given options
@@ -22,9 +52,7 @@ func NewBatchRunner(opts ...BatchRunnerOption) *BatchRunner {
 	r := &BatchRunner{
 		client:     http.DefaultClient,
 		maxWorkers: runtime.GOMAXPROCS(0),
-		saveFn: func(text string, audio []byte) error {
-			return os.WriteFile(text+".mp3", audio, 0600)
-		},
+		outDir:     ".",
 	}
 
 	for _, opt := range opts {
@@ -44,6 +72,13 @@ func WithClient(c *http.Client) BatchRunnerOption {
 	}
 }
 
+// WithSynthesizer sets the backend that produces the audio
+func WithSynthesizer(s Synthesizer) BatchRunnerOption {
+	return func(r *BatchRunner) {
+		r.synthesizer = s
+	}
+}
+
 // WithMaxWorkers sets the maximum number of concurrent workers
 func WithMaxWorkers(n int) BatchRunnerOption {
 	return func(r *BatchRunner) {
@@ -51,33 +86,587 @@ func WithMaxWorkers(n int) BatchRunnerOption {
 	}
 }
 
+// WithAdaptiveConcurrency adapts the number of concurrent workers between minWorkers and maxWorkers
+func WithAdaptiveConcurrency(minWorkers, maxWorkers int) BatchRunnerOption {
+	return func(r *BatchRunner) {
+		r.adaptive = true
+		r.adaptiveMin = minWorkers
+		r.maxWorkers = maxWorkers
+	}
+}
+
+// WithRetry sets the policy for retrying transient failures
+func WithRetry(p RetryPolicy) BatchRunnerOption {
+	return func(r *BatchRunner) {
+		r.retry = p
+	}
+}
+
+// WithRateLimit limits the requests of the runner to rate per second with bursts of burst
+func WithRateLimit(rate float64, burst int) BatchRunnerOption {
+	return func(r *BatchRunner) {
+		r.limiter = NewLimiter(rate, burst)
+	}
+}
+
+// WithLimiter sets the limiter for the requests of the runner
+func WithLimiter(l *Limiter) BatchRunnerOption {
+	return func(r *BatchRunner) {
+		r.limiter = l
+	}
+}
+
 // WithSaveFunc sets custom save function
 func WithSaveFunc(fn func(string, []byte) error) BatchRunnerOption {
+	return WithSaver(SaverFunc(func(_ context.Context, e Entry, audio io.Reader) (string, error) {
+		b, err := io.ReadAll(audio)
+		if err != nil {
+			return "", fmt.Errorf("io.ReadAll(): %w", err)
+		}
+		return "", fn(e.Opt.Text, b)
+	}))
+}
+
+// WithSaver sets where the audio is saved
+func WithSaver(s Saver) BatchRunnerOption {
+	return func(r *BatchRunner) {
+		r.saver = s
+	}
+}
+
+// WithSkipExisting skips the Opts whose audio already exists
+func WithSkipExisting() BatchRunnerOption {
+	return func(r *BatchRunner) {
+		r.skipExisting = true
+	}
+}
+
+// ErrNoExister occurs when WithSkipExisting can't tell whether audio exists,
+// since the Saver isn't an Exister and WithExistsFunc isn't set
+var ErrNoExister = errors.New("saver can't tell whether audio exists")
+
+// WithExistsFunc sets how WithSkipExisting checks whether audio exists
+func WithExistsFunc(fn func(string, Opt) (bool, error)) BatchRunnerOption {
+	return func(r *BatchRunner) {
+		r.existsFn = fn
+	}
+}
+
+// WithState records the completed Opts to s and skips the ones it has
+func WithState(s *State) BatchRunnerOption {
+	return func(r *BatchRunner) {
+		r.state = s
+	}
+}
+
+// WithCache caches the synthesized audio in c
+func WithCache(c *Cache) BatchRunnerOption {
+	return func(r *BatchRunner) {
+		r.cache = c
+	}
+}
+
+// WithTagging writes the ID3v2.4 tags of t into the audio
+func WithTagging(t Tagging) BatchRunnerOption {
+	return func(r *BatchRunner) {
+		r.tagging = &t
+	}
+}
+
+// WithManifest writes a ManifestEntry for every Opt to m
+func WithManifest(m *Manifest) BatchRunnerOption {
+	return func(r *BatchRunner) {
+		r.manifest = m
+	}
+}
+
+// WithProgress calls fn with the Events of a batch
+func WithProgress(fn func(Event)) BatchRunnerOption {
+	return func(r *BatchRunner) {
+		r.progress = fn
+	}
+}
+
+// WithLogger sets the logger
+func WithLogger(l *slog.Logger) BatchRunnerOption {
 	return func(r *BatchRunner) {
-		r.saveFn = fn
+		r.logger = l
+	}
+}
+
+// WithFilenameFunc sets how the files of the Opts are named
+func WithFilenameFunc(fn FilenameFunc) BatchRunnerOption {
+	return func(r *BatchRunner) {
+		r.filename = fn
+	}
+}
+
+// WithOutputDir sets the directory the default Saver writes to
+func WithOutputDir(dir string) BatchRunnerOption {
+	return func(r *BatchRunner) {
+		r.outDir = dir
+	}
+}
+
+// WithContinueOnError makes the runner attempt every Opt even when some of them fail
+func WithContinueOnError() BatchRunnerOption {
+	return func(r *BatchRunner) {
+		r.keepGoing = true
+	}
+}
+
+// Result is the outcome of an Opt of a batch
+type Result struct {
+	// Index is the position of the Opt in the batch counted from 0
+	Index int
+	Opt   Opt
+	// File is where the audio is saved relative to the output directory
+	File string
+	// Path is where the audio is saved as the Saver tells, such as File under the output directory or the URL of an object,
+	// it is File under the output directory if the audio isn't saved or the Saver doesn't tell
+	Path string
+	// Size is the size of the audio in bytes
+	Size int
+	// SHA256 is the hex encoded SHA-256 of the audio
+	SHA256 string
+	// AudioDuration is how long the audio plays as the MP3 frames tell, it is 0 if the audio isn't MP3
+	AudioDuration time.Duration
+	// Stream describes the MP3 stream of the audio, it is zero if the audio isn't MP3
+	Stream StreamInfo
+	// Latency is how long the Opt took from its first request until it was saved, retries included
+	Latency time.Duration
+	// Attempts is how many times the Opt was synthesized
+	Attempts int
+	// Skipped is true if the Opt was already done and wasn't synthesized, see WithSkipExisting and WithState
+	Skipped bool
+	// Cached is true if the audio came from the cache, see WithCache
+	Cached bool
+	Err    error
+}
+
+// failure returns the Failure of a failed Result
+func (res Result) failure() Failure {
+	return Failure{Index: res.Index, Opt: res.Opt, File: res.File, Attempts: res.Attempts, Err: res.Err}
+}
+
+// batch is what a run is given, total is the number of its opts or -1 if it isn't known
+// and name returns the filename of the opt at an index
+type batch struct {
+	seq   iter.Seq[Opt]
+	total int
+	name  func(int, Opt) (string, error)
+	// opts are the opts of a batch of a slice, which are known even if they never start
+	opts []Opt
+}
+
+// check fails if the options of the runner don't go together
+func (r *BatchRunner) check() error {
+	if !r.skipExisting || r.existsFn != nil || r.saver == nil {
+		return nil
+	}
+	if _, ok := r.saver.(Exister); !ok {
+		return fmt.Errorf("saver(%T): %w", r.saver, ErrNoExister)
 	}
+	return nil
+}
+
+// sliceBatch returns the batch of opts, it checks the filenames of every opt upfront
+func (r *BatchRunner) sliceBatch(opts []Opt) (batch, error) {
+	if err := r.check(); err != nil {
+		return batch{}, err
+	}
+	names, err := filenames(opts, r.filename)
+	if err != nil {
+		return batch{}, err
+	}
+	return batch{
+		seq:   slices.Values(opts),
+		total: len(opts),
+		name:  func(i int, _ Opt) (string, error) { return names[i], nil },
+		opts:  opts,
+	}, nil
 }
 
-// Run runs given opts concurrently and stops if encounters an error
+// seqBatch returns the batch of the opts of seq, it checks the filename of every opt as it comes
+func (r *BatchRunner) seqBatch(seq iter.Seq[Opt]) (batch, error) {
+	if err := r.check(); err != nil {
+		return batch{}, err
+	}
+	return batch{seq: seq, total: -1, name: newFilenameChecker(r.filename).name}, nil
+}
+
+// start runs the opts of b in the background and sends the result of every opt to the returned channel as it completes,
+// the channel is closed once all opts are done so it must be drained. Opts are pulled from b only when a worker is free,
+// and cancelling ctx stops pulling them and stops the opts that haven't completed
+func (r *BatchRunner) start(ctx context.Context, b batch) <-chan Result {
+	synthesizer := r.synthesizer
+	if synthesizer == nil {
+		synthesizer = &Client{HTTPClient: r.client, Logger: r.logger}
+	}
+	// a Client takes a token for every request it sends, other synthesizers take one for every call
+	limiter := r.limiter
+	if c, ok := synthesizer.(*Client); ok && c.Limiter == nil && limiter != nil {
+		client := *c
+		client.Limiter = limiter
+		synthesizer, limiter = &client, nil
+	}
+	logger := r.logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+
+	backend := fmt.Sprintf("%T", synthesizer)
+	if b, ok := synthesizer.(interface{ Backend() string }); ok {
+		backend = b.Backend()
+	}
+
+	saver := r.saver
+	if saver == nil {
+		saver = NewDirSaver(r.outDir)
+	}
+	exists := r.existsFn
+	if e, ok := saver.(Exister); ok && exists == nil {
+		exists = func(name string, opt Opt) (bool, error) {
+			return e.Exists(ctx, Entry{Name: name, Opt: opt})
+		}
+	}
+	if !r.skipExisting {
+		exists = nil
+	}
+
+	var adaptive *adaptiveLimit
+	if r.adaptive {
+		adaptive = newAdaptiveLimit(r.adaptiveMin, r.maxWorkers)
+	} else {
+		r.concurrency.Store(int64(r.maxWorkers))
+	}
+
+	progress := newProgressTracker(r.progress, b.total)
+
+	run := func(i int, opt Opt) (res Result) {
+		progress.emit(Event{Kind: EventStarted, Index: i, Opt: opt})
+		logger := logger.With("index", i, "voice", opt.Voice)
+		defer func() {
+			if res.Err != nil {
+				logger.WarnContext(ctx, "row failed", "attempts", res.Attempts, "err", res.Err)
+				progress.emit(Event{Kind: EventFailed, Index: i, Opt: opt, Err: res.Err})
+			} else {
+				progress.emit(Event{Kind: EventSucceeded, Index: i, Opt: opt})
+			}
+		}()
+
+		res = Result{Index: i, Opt: opt}
+		if res.File, res.Err = b.name(i, opt); res.Err != nil {
+			return res
+		}
+		res.Path = filepath.Join(r.outDir, res.File)
+		start := time.Now()
+		defer func() { res.Latency = time.Since(start) }()
+
+		if r.state.Done(res.File, opt) {
+			logger.DebugContext(ctx, "row skipped", "file", res.File, "reason", "state")
+			res.Skipped = true
+			return res
+		}
+		if exists != nil {
+			if res.Skipped, res.Err = exists(res.File, opt); res.Err != nil {
+				res.Err = fmt.Errorf("%T.ExistsFunc(%v): %w", r, res.File, res.Err)
+				return res
+			}
+			if res.Skipped {
+				logger.DebugContext(ctx, "row skipped", "file", res.File, "reason", "exists")
+				return res
+			}
+		}
+
+		var (
+			audio []byte
+			key   string
+		)
+		if r.cache != nil {
+			key = CacheKey(backend, opt)
+			audio, res.Cached = r.cache.Get(key)
+			if res.Cached {
+				logger.DebugContext(ctx, "cache hit", "key", key)
+			}
+		}
+		if !res.Cached {
+			res.Attempts, res.Err = r.retry.do(ctx, func(ctx context.Context) (err error) {
+				if err := limiter.Wait(ctx); err != nil {
+					return fmt.Errorf("%T.Wait(): %w", limiter, err)
+				}
+				if err := adaptive.acquire(ctx); err != nil {
+					return err
+				}
+				start := time.Now()
+				audio, err = synthesizer.Synthesize(ctx, opt)
+				adaptive.release(start, err)
+				return err
+			}, func(attempt int, err error) {
+				logger.InfoContext(ctx, "row retried", "attempt", attempt, "err", err)
+				progress.emit(Event{Kind: EventRetried, Index: i, Opt: opt, Attempt: attempt, Err: err})
+			})
+			if res.Err != nil {
+				res.Err = fmt.Errorf("%T.Synthesize(%v): %w", synthesizer, opt, res.Err)
+				return res
+			}
+			if r.cache != nil {
+				// the cache only saves requests, so the audio is saved even if it can't be cached
+				if err := r.cache.Put(key, audio); err != nil {
+					logger.WarnContext(ctx, "cache put failed", "key", key, "err", err)
+				}
+			}
+		}
+
+		if r.tagging != nil {
+			audio = TagMP3(audio, r.tagging.Tags(i, opt))
+		}
+		location, err := saver.Save(ctx, Entry{Index: i, Name: res.File, Opt: opt}, bytes.NewReader(audio))
+		if err != nil {
+			res.Err = fmt.Errorf("%T.Save(%v): %w", saver, res.File, err)
+			return res
+		}
+		if location != "" {
+			res.Path = location
+		}
+		res.Size = len(audio)
+		sum := sha256.Sum256(audio)
+		res.SHA256 = hex.EncodeToString(sum[:])
+		res.Stream, _ = ParseMP3(audio)
+		res.AudioDuration = res.Stream.Duration
+		if err := r.state.Add(res.File, opt); err != nil {
+			res.Err = fmt.Errorf("%T.Add(%v): %w", r.state, res.File, err)
+			return res
+		}
+		logger.DebugContext(ctx, "row saved", "path", res.Path, "size", res.Size, "duration", res.AudioDuration, "attempts", res.Attempts)
+		return res
+	}
+
+	results := make(chan Result)
+	go func() {
+		defer close(results)
+		p := pool.New().WithMaxGoroutines(r.maxWorkers)
+		var i int
+		for opt := range b.seq {
+			if ctx.Err() != nil {
+				break
+			}
+			index := i
+			progress.emit(Event{Kind: EventQueued, Index: index, Opt: opt})
+			// blocks while every worker is busy
+			p.Go(func() {
+				res := run(index, opt)
+				if r.manifest != nil {
+					if err := r.manifest.Write(res); err != nil && res.Err == nil {
+						res.Err = fmt.Errorf("%T.Write(%d): %w", r.manifest, index, err)
+					}
+				}
+				results <- res
+			})
+			i++
+		}
+		p.Wait()
+		if adaptive != nil {
+			r.concurrency.Store(int64(adaptive.current()))
+		}
+	}()
+	return results
+}
+
+// Run runs given opts concurrently and stops at the first error, unless WithContinueOnError is set,
+// it fails with ErrDuplicateFilename before running anything if several opts would be saved to the same file
 func (r *BatchRunner) Run(ctx context.Context, opts []Opt) error {
-	p := pool.New().WithContext(ctx).WithMaxGoroutines(r.maxWorkers)
+	b, err := r.sliceBatch(opts)
+	if err != nil {
+		return err
+	}
+	return r.run(ctx, b)
+}
 
-	for _, opt := range opts {
-		p.Go(func(ctx context.Context) error {
-			audio, err := Run(ctx, r.client, opt)
-			if err != nil {
-				return fmt.Errorf("Run(%v): %w", opt, err)
+// RunSeq runs the opts of seq as they come like Run, so batches don't have to be loaded in memory,
+// seq is pulled only as fast as the workers take opts, and unsafe or duplicate filenames fail the opts that have them
+func (r *BatchRunner) RunSeq(ctx context.Context, seq iter.Seq[Opt]) error {
+	b, err := r.seqBatch(seq)
+	if err != nil {
+		return err
+	}
+	return r.run(ctx, b)
+}
+
+// run is the implementation of Run and RunSeq
+func (r *BatchRunner) run(ctx context.Context, b batch) error {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		first    error
+		failures []Failure
+		total    int
+		started  = make([]bool, len(b.opts))
+	)
+	for res := range r.start(runCtx, b) {
+		total++
+		if res.Index < len(started) {
+			started[res.Index] = true
+		}
+		switch {
+		case res.Err == nil:
+		case r.keepGoing:
+			failures = append(failures, res.failure())
+		case first == nil:
+			first = res.Err
+			cancel()
+		}
+	}
+	if first != nil {
+		return first
+	}
+	if r.keepGoing && ctx.Err() != nil {
+		// the opts of a slice that never started failed along with the batch, so they can be run again
+		for i, opt := range b.opts {
+			if !started[i] {
+				name, _ := b.name(i, opt)
+				failures = append(failures, Failure{Index: i, Opt: opt, File: name, Err: ctx.Err()})
 			}
+		}
+	}
+	if b.total >= 0 {
+		total = b.total
+	}
+	if len(failures) == 0 {
+		// the opts that were never pulled from a cancelled seq didn't fail, but the batch didn't finish either
+		return ctx.Err()
+	}
+	slices.SortFunc(failures, func(a, b Failure) int { return a.Index - b.Index })
+	return &BatchError{Failures: failures, Total: total}
+}
 
-			if err := r.saveFn(opt.Text, audio); err != nil {
-				return fmt.Errorf("%T.SaveFunc(%v): %w", p, opt.Text, err)
+// RunResults runs every opt like WithContinueOnError and returns their results in the order of opts,
+// it returns a *BatchError along with the results if any of them failed
+func (r *BatchRunner) RunResults(ctx context.Context, opts []Opt) ([]Result, error) {
+	b, err := r.sliceBatch(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Result, len(opts))
+	done := make([]bool, len(opts))
+	for res := range r.start(ctx, b) {
+		out[res.Index] = res
+		done[res.Index] = true
+	}
+
+	var failures []Failure
+	for i := range out {
+		if !done[i] { // the batch was cancelled before the opt started
+			name, _ := b.name(i, opts[i])
+			out[i] = Result{Index: i, Opt: opts[i], File: name, Path: filepath.Join(r.outDir, name), Err: ctx.Err()}
+		}
+		if out[i].Err != nil {
+			failures = append(failures, out[i].failure())
+		}
+	}
+	if len(failures) > 0 {
+		return out, &BatchError{Failures: failures, Total: len(opts)}
+	}
+	return out, nil
+}
+
+// Results runs every opt like WithContinueOnError and yields their results as they complete along with their error,
+// it yields a single error such as ErrDuplicateFilename if the batch can't start and a last error if ctx is cancelled,
+// and stopping early cancels the rest of the opts
+func (r *BatchRunner) Results(ctx context.Context, opts []Opt) iter.Seq2[Result, error] {
+	b, err := r.sliceBatch(opts)
+	if err != nil {
+		return func(yield func(Result, error) bool) {
+			yield(Result{}, err)
+		}
+	}
+	return r.results(ctx, b)
+}
+
+// ResultsSeq runs the opts of seq as they come like Results, a channel of Opts can be turned into seq by ranging over it
+func (r *BatchRunner) ResultsSeq(ctx context.Context, seq iter.Seq[Opt]) iter.Seq2[Result, error] {
+	b, err := r.seqBatch(seq)
+	if err != nil {
+		return func(yield func(Result, error) bool) {
+			yield(Result{}, err)
+		}
+	}
+	return r.results(ctx, b)
+}
+
+// results is the implementation of Results and ResultsSeq
+func (r *BatchRunner) results(ctx context.Context, b batch) iter.Seq2[Result, error] {
+	return func(yield func(Result, error) bool) {
+		runCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		results := r.start(runCtx, b)
+		for res := range results {
+			if !yield(res, res.Err) {
+				cancel()
+				for range results {
+				}
+				return
 			}
-			return nil
-		})
+		}
+		if err := ctx.Err(); err != nil {
+			yield(Result{}, err)
+		}
 	}
+}
+
+// Failure is an Opt of a batch that failed
+type Failure struct {
+	// Index is the position of the Opt in the batch counted from 0
+	Index int
+	Opt   Opt
+	// File is where the audio would have been saved relative to the output directory
+	File string
+	// Attempts is how many times the Opt was synthesized
+	Attempts int
+	Err      error
+}
+
+// BatchError holds every failed Opt of a batch that continued on error, sorted by their index
+type BatchError struct {
+	Failures []Failure
+	// Total is the number of Opts in the batch, it doesn't count the Opts a cancelled RunSeq never pulled
+	Total int
+}
 
-	if err := p.Wait(); err != nil {
-		return fmt.Errorf("%T.Wait(): %w", p, err)
+func (e *BatchError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d rows failed", len(e.Failures), e.Total)
+	for _, f := range e.Failures {
+		fmt.Fprintf(&b, "\nrow(%d) attempts(%d): %v", f.Index+1, f.Attempts, f.Err)
 	}
-	return nil
+	return b.String()
+}
+
+// Unwrap returns the errors of the failures so errors.Is and errors.As can look into them
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// Opts returns the failed Opts to be run again, their File is set to the file of the failure
+// so a re-run saves them where the batch would have saved them even if their filename depends on the index
+func (e *BatchError) Opts() []Opt {
+	opts := make([]Opt, len(e.Failures))
+	for i, f := range e.Failures {
+		opts[i] = f.Opt
+		opts[i].File = f.File
+	}
+	return opts
+}
+
+// Concurrency returns the number of concurrent requests of the last run,
+// which is where adaptive concurrency settled or the maximum number of workers otherwise
+func (r *BatchRunner) Concurrency() int {
+	return int(r.concurrency.Load())
 }
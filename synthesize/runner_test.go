@@ -1,18 +1,32 @@
 package synthesize
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
-	"net/http"
+	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/lingua-sensei/laverna/synthesize/synthesizetest"
 	"github.com/mrwormhole/errdiff"
 )
 
 func TestBatchRunner(t *testing.T) {
 	const maxWorkers = 5
+	server := synthesizetest.NewServer()
+	defer server.Close()
 	saveErr := errors.New("save error")
 	temp := t.TempDir()
 
@@ -68,7 +82,7 @@ func TestBatchRunner(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			runner := NewBatchRunner(
-				WithClient(&http.Client{}),
+				WithClient(server.Client()),
 				WithMaxWorkers(maxWorkers),
 				WithSaveFunc(tt.saveFn),
 			)
@@ -91,3 +105,500 @@ func TestBatchRunner(t *testing.T) {
 		})
 	}
 }
+
+func TestBatchRunner_DefaultSave(t *testing.T) {
+	server := synthesizetest.NewServer()
+	defer server.Close()
+	t.Chdir(t.TempDir())
+
+	opts := []Opt{
+		{Text: "a/b: c?", Voice: EnglishVoice},
+		{Text: "a/b: c?", Voice: EnglishVoice, Speed: SlowestSpeed},
+	}
+	runner := NewBatchRunner(WithClient(server.Client()))
+	if err := runner.Run(t.Context(), opts); err != nil {
+		t.Fatalf("%T.Run(): %v", runner, err)
+	}
+	for _, opt := range opts {
+		if _, err := os.Stat(Filename(opt)); err != nil {
+			t.Errorf("os.Stat(%q): %v", Filename(opt), err)
+		}
+	}
+
+	err := runner.Run(t.Context(), append(opts, opts[0]))
+	if diff := errdiff.Check(err, ErrDuplicateFilename); diff != "" {
+		t.Errorf("%T.Run(): err diff=\n%s", runner, diff)
+	}
+	if got := len(server.Requests()); got != len(opts) {
+		t.Errorf("%T.Run(): got %d requests, want no requests after duplicates", runner, got-len(opts))
+	}
+}
+
+func TestBatchRunner_WithOutputDir(t *testing.T) {
+	server := synthesizetest.NewServer()
+	defer server.Close()
+	dir := t.TempDir()
+
+	filename, err := FilenameTemplate("{{.Voice}}/{{.Index}}-{{.Slug}}.mp3")
+	if err != nil {
+		t.Fatalf("FilenameTemplate(): %v", err)
+	}
+	runner := NewBatchRunner(
+		WithClient(server.Client()),
+		WithOutputDir(dir),
+		WithFilenameFunc(filename),
+	)
+	opts := []Opt{
+		{Text: "hello", Voice: EnglishVoice},
+		{Text: "สวัสดี", Voice: ThaiVoice},
+	}
+	if err := runner.Run(t.Context(), opts); err != nil {
+		t.Fatalf("%T.Run(): %v", runner, err)
+	}
+
+	for _, want := range []string{"en/0-hello.mp3", "th/1-สวัสดี.mp3"} {
+		if _, err := os.Stat(filepath.Join(dir, want)); err != nil {
+			t.Errorf("os.Stat(%q): %v", want, err)
+		}
+	}
+}
+
+func TestBatchRunner_WithSynthesizer(t *testing.T) {
+	synthErr := errors.New("synthesize error")
+
+	tests := []struct {
+		name        string
+		opts        []Opt
+		synthesizer SynthesizerFunc
+		wantErr     error
+		wantSaved   map[string]string
+	}{
+		{
+			name: "custom backend",
+			opts: []Opt{
+				{Text: "test1", Voice: EnglishVoice},
+				{Text: "test2", Voice: ThaiVoice, Speed: SlowestSpeed},
+			},
+			synthesizer: func(_ context.Context, opt Opt) ([]byte, error) {
+				return []byte(opt.Text + "/" + string(opt.Voice) + "/" + opt.Speed.String()), nil
+			},
+			wantSaved: map[string]string{
+				"test1": "test1/en/normal",
+				"test2": "test2/th/slowest",
+			},
+		},
+		{
+			name: "backend error",
+			opts: []Opt{
+				{Text: "test3", Voice: EnglishVoice},
+			},
+			synthesizer: func(context.Context, Opt) ([]byte, error) {
+				return nil, synthErr
+			},
+			wantErr:   synthErr,
+			wantSaved: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			saved := make(map[string]string)
+			runner := NewBatchRunner(
+				WithSynthesizer(tt.synthesizer),
+				WithSaveFunc(func(text string, audio []byte) error {
+					mu.Lock()
+					defer mu.Unlock()
+					saved[text] = string(audio)
+					return nil
+				}),
+			)
+
+			err := runner.Run(t.Context(), tt.opts)
+			if diff := errdiff.Check(err, tt.wantErr); diff != "" {
+				t.Errorf("%T.Run(): err diff=\n%s", runner, diff)
+			}
+			if diff := cmp.Diff(tt.wantSaved, saved); diff != "" {
+				t.Errorf("%T.Run(): saved diff=\n%s", runner, diff)
+			}
+		})
+	}
+}
+
+func TestBatchRunner_WithContinueOnError(t *testing.T) {
+	opts := []Opt{
+		{Text: "test1", Voice: EnglishVoice},
+		{Text: "fail2", Voice: EnglishVoice},
+		{Text: "test3", Voice: EnglishVoice},
+		{Text: "fail4", Voice: ThaiVoice, File: "th/fail4.mp3"},
+	}
+
+	var mu sync.Mutex
+	var saved []string
+	runner := NewBatchRunner(
+		WithMaxWorkers(1),
+		WithContinueOnError(),
+		WithRetry(RetryPolicy{MaxAttempts: 2}),
+		WithSynthesizer(SynthesizerFunc(func(_ context.Context, opt Opt) ([]byte, error) {
+			if strings.HasPrefix(opt.Text, "fail") {
+				return nil, ErrNoAudio
+			}
+			return []byte(opt.Text), nil
+		})),
+		WithSaveFunc(func(text string, _ []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			saved = append(saved, text)
+			return nil
+		}),
+	)
+
+	err := runner.Run(t.Context(), opts)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("%T.Run(): got err = %v, want %T", runner, err, batchErr)
+	}
+	if !errors.Is(err, ErrNoAudio) {
+		t.Errorf("%T.Run(): got err = %v, want it to wrap %v", runner, err, ErrNoAudio)
+	}
+	if diff := cmp.Diff([]string{"test1", "test3"}, saved); diff != "" {
+		t.Errorf("%T.Run(): saved diff=\n%s", runner, diff)
+	}
+
+	if batchErr.Total != len(opts) {
+		t.Errorf("%T.Total: got = %d, want = %d", batchErr, batchErr.Total, len(opts))
+	}
+	var got []Failure
+	for _, f := range batchErr.Failures {
+		got = append(got, Failure{Index: f.Index, File: f.File, Attempts: f.Attempts})
+	}
+	want := []Failure{
+		{Index: 1, File: "fail2_en_normal.mp3", Attempts: 2},
+		{Index: 3, File: "th/fail4.mp3", Attempts: 2},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("%T.Failures: diff=\n%s", batchErr, diff)
+	}
+
+	wantOpts := []Opt{
+		{Text: "fail2", Voice: EnglishVoice, File: "fail2_en_normal.mp3"},
+		{Text: "fail4", Voice: ThaiVoice, File: "th/fail4.mp3"},
+	}
+	if diff := cmp.Diff(wantOpts, batchErr.Opts()); diff != "" {
+		t.Errorf("%T.Opts(): diff=\n%s", batchErr, diff)
+	}
+}
+
+func TestBatchRunner_WithContinueOnError_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	opts := make([]Opt, 10)
+	for i := range opts {
+		opts[i] = Opt{Text: fmt.Sprintf("test%d", i+1), Voice: EnglishVoice}
+	}
+	runner := NewBatchRunner(
+		WithMaxWorkers(1),
+		WithContinueOnError(),
+		WithSynthesizer(SynthesizerFunc(func(ctx context.Context, opt Opt) ([]byte, error) {
+			if opt.Text == "test2" {
+				cancel()
+				return nil, ctx.Err()
+			}
+			return []byte(opt.Text), nil
+		})),
+		WithSaveFunc(func(string, []byte) error { return nil }),
+	)
+
+	err := runner.Run(ctx, opts)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("%T.Run(): got err = %v, want %T", runner, err, batchErr)
+	}
+	// the rows that never started failed too, so running the failures again finishes the batch
+	if batchErr.Total != len(opts) || len(batchErr.Opts()) != len(opts)-1 {
+		t.Errorf("%T.Run(): got %d of %d rows failed, want %d of %d", runner, len(batchErr.Opts()), batchErr.Total, len(opts)-1, len(opts))
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("%T.Run(): got err = %v, want it to wrap %v", runner, err, context.Canceled)
+	}
+}
+
+func TestBatchRunner_RunResults(t *testing.T) {
+	server := synthesizetest.NewServer(synthesizetest.WithFaults(synthesizetest.NoFault, synthesizetest.Unavailable))
+	defer server.Close()
+	dir := t.TempDir()
+
+	runner := NewBatchRunner(
+		WithClient(server.Client()),
+		WithMaxWorkers(1),
+		WithOutputDir(dir),
+		WithRetry(RetryPolicy{MaxAttempts: 2}),
+	)
+	opts := []Opt{
+		{Text: "hi", Voice: EnglishVoice},
+		{Text: "bye", Voice: EnglishVoice, File: "bye.mp3"},
+		{Text: "nope", Voice: "xx"},
+	}
+	results, err := runner.RunResults(t.Context(), opts)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Failures) != 1 || !errors.Is(err, ErrUnknownVoice) {
+		t.Fatalf("%T.RunResults(): got err = %v, want a %T of %v", runner, err, batchErr, ErrUnknownVoice)
+	}
+
+	for _, res := range results {
+		if res.Err == nil && res.Latency <= 0 {
+			t.Errorf("%T.RunResults(): result(%d) latency = %v, want > 0", runner, res.Index, res.Latency)
+		}
+	}
+	frames := func(text string) int { return len(synthesizetest.Audio(text, 0)) / 96 }
+	hash := func(text string) string {
+		sum := sha256.Sum256(synthesizetest.Audio(text, 0))
+		return hex.EncodeToString(sum[:])
+	}
+	stream := func(text string) StreamInfo {
+		return StreamInfo{
+			Version:    2,
+			Layer:      3,
+			Bitrate:    32000,
+			SampleRate: 24000,
+			Channels:   1,
+			Frames:     frames(text),
+			Duration:   time.Duration(frames(text)) * 24 * time.Millisecond,
+		}
+	}
+	want := []Result{
+		{
+			Index:         0,
+			Opt:           opts[0],
+			File:          "hi_en_normal.mp3",
+			Path:          filepath.Join(dir, "hi_en_normal.mp3"),
+			Size:          len(synthesizetest.Audio("hi", 0)),
+			SHA256:        hash("hi"),
+			AudioDuration: time.Duration(frames("hi")) * 24 * time.Millisecond,
+			Stream:        stream("hi"),
+			Attempts:      1,
+		},
+		{
+			Index:         1,
+			Opt:           opts[1],
+			File:          "bye.mp3",
+			Path:          filepath.Join(dir, "bye.mp3"),
+			Size:          len(synthesizetest.Audio("bye", 0)),
+			SHA256:        hash("bye"),
+			AudioDuration: time.Duration(frames("bye")) * 24 * time.Millisecond,
+			Stream:        stream("bye"),
+			Attempts:      2,
+		},
+		{
+			Index:    2,
+			Opt:      opts[2],
+			File:     "nope_xx_normal.mp3",
+			Path:     filepath.Join(dir, "nope_xx_normal.mp3"),
+			Attempts: 1,
+		},
+	}
+	ignore := cmpopts.IgnoreFields(Result{}, "Latency", "Err")
+	if diff := cmp.Diff(want, results, ignore); diff != "" {
+		t.Errorf("%T.RunResults(): results diff=\n%s", runner, diff)
+	}
+}
+
+func TestBatchRunner_Results(t *testing.T) {
+	server := synthesizetest.NewServer()
+	defer server.Close()
+
+	runner := NewBatchRunner(
+		WithClient(server.Client()),
+		WithMaxWorkers(1),
+		WithSaveFunc(func(string, []byte) error { return nil }),
+	)
+	var opts []Opt
+	for i := range 10 {
+		opts = append(opts, Opt{Text: fmt.Sprintf("test%d", i), Voice: EnglishVoice})
+	}
+
+	var got []int
+	for res, err := range runner.Results(t.Context(), opts) {
+		if err != nil {
+			t.Fatalf("%T.Results(): %v", runner, err)
+		}
+		got = append(got, res.Index)
+		if len(got) == 3 {
+			break
+		}
+	}
+	if len(got) != 3 {
+		t.Errorf("%T.Results(): got %d results, want %d", runner, len(got), 3)
+	}
+	// the worker that was running when the loop stopped may still have sent its request
+	if n := len(server.Requests()); n > 4 {
+		t.Errorf("%T.Results(): got %d requests, want the rest cancelled after breaking", runner, n)
+	}
+
+	for _, err := range runner.Results(t.Context(), append(opts, opts[0])) {
+		if diff := errdiff.Check(err, ErrDuplicateFilename); diff != "" {
+			t.Errorf("%T.Results(): err diff=\n%s", runner, diff)
+		}
+	}
+}
+
+func TestBatchRunner_RunSeq(t *testing.T) {
+	const maxWorkers = 2
+	release := make(chan struct{})
+	var pulled atomic.Int64
+	seq := func(yield func(Opt) bool) {
+		for i := range 100 {
+			pulled.Add(1)
+			if !yield(Opt{Text: fmt.Sprintf("test%d", i%99), Voice: EnglishVoice}) {
+				return
+			}
+		}
+	}
+
+	var mu sync.Mutex
+	saved := make(map[string]bool)
+	runner := NewBatchRunner(
+		WithMaxWorkers(maxWorkers),
+		WithContinueOnError(),
+		WithSynthesizer(SynthesizerFunc(func(_ context.Context, opt Opt) ([]byte, error) {
+			<-release
+			return []byte(opt.Text), nil
+		})),
+		WithSaveFunc(func(text string, _ []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			saved[text] = true
+			return nil
+		}),
+	)
+
+	errc := make(chan error)
+	go func() { errc <- runner.RunSeq(t.Context(), seq) }()
+	time.Sleep(20 * time.Millisecond)
+	// every worker is busy and the next opt waits for one of them
+	if got := pulled.Load(); got > maxWorkers+1 {
+		t.Errorf("%T.RunSeq(): pulled %d opts while %d workers were busy, want at most %d", runner, got, maxWorkers, maxWorkers+1)
+	}
+	close(release)
+
+	err := <-errc
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || !errors.Is(err, ErrDuplicateFilename) {
+		t.Fatalf("%T.RunSeq(): got err = %v, want a %T of %v", runner, err, batchErr, ErrDuplicateFilename)
+	}
+	if len(batchErr.Failures) != 1 || batchErr.Failures[0].Index != 99 || batchErr.Total != 100 {
+		t.Errorf("%T.RunSeq(): got failures = %v of %d, want row 100 of 100 to fail", runner, batchErr.Failures, batchErr.Total)
+	}
+	if len(saved) != 99 {
+		t.Errorf("%T.RunSeq(): saved %d opts, want %d", runner, len(saved), 99)
+	}
+}
+
+func TestBatchRunner_WithSkipExisting(t *testing.T) {
+	server := synthesizetest.NewServer()
+	defer server.Close()
+	dir := t.TempDir()
+
+	opts := []Opt{
+		{Text: "test1", Voice: EnglishVoice},
+		{Text: "test2", Voice: EnglishVoice},
+	}
+	audio := synthesizetest.Audio(opts[0].Text, 0)
+	if err := os.WriteFile(filepath.Join(dir, Filename(opts[0])), audio, 0600); err != nil {
+		t.Fatalf("os.WriteFile(): %v", err)
+	}
+	// a truncated file is left by a write that didn't finish, it is not skipped
+	if err := os.WriteFile(filepath.Join(dir, Filename(opts[1])), audio[:len(audio)-10], 0600); err != nil {
+		t.Fatalf("os.WriteFile(): %v", err)
+	}
+
+	runner := NewBatchRunner(WithClient(server.Client()), WithOutputDir(dir), WithSkipExisting())
+	results, err := runner.RunResults(t.Context(), opts)
+	if err != nil {
+		t.Fatalf("%T.RunResults(): %v", runner, err)
+	}
+	var skipped []bool
+	for _, res := range results {
+		skipped = append(skipped, res.Skipped)
+	}
+	if diff := cmp.Diff([]bool{true, false}, skipped); diff != "" {
+		t.Errorf("%T.RunResults(): skipped diff=\n%s", runner, diff)
+	}
+	if got := len(server.Requests()); got != 1 {
+		t.Errorf("%T.RunResults(): got %d requests, want %d", runner, got, 1)
+	}
+
+	existsErr := errors.New("exists error")
+	runner = NewBatchRunner(
+		WithClient(server.Client()),
+		WithSkipExisting(),
+		WithExistsFunc(func(string, Opt) (bool, error) { return false, existsErr }),
+		WithSaveFunc(func(string, []byte) error { return nil }),
+	)
+	err = runner.Run(t.Context(), opts)
+	if diff := errdiff.Check(err, existsErr); diff != "" {
+		t.Errorf("%T.Run(): err diff=\n%s", runner, diff)
+	}
+}
+
+func TestBatchRunner_WithLogger(t *testing.T) {
+	server := synthesizetest.NewServer(synthesizetest.WithFaults(
+		synthesizetest.RateLimited,
+		synthesizetest.NoFault,
+		synthesizetest.MalformedBody,
+		synthesizetest.MalformedBody,
+	))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	runner := NewBatchRunner(
+		WithClient(server.Client()),
+		WithMaxWorkers(1),
+		WithContinueOnError(),
+		WithRetry(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+		WithSaveFunc(func(string, []byte) error { return nil }),
+		WithLogger(logger),
+	)
+	opts := []Opt{
+		{Text: "test1", Voice: EnglishVoice},
+		{Text: "test2", Voice: ThaiVoice},
+	}
+	_ = runner.Run(t.Context(), opts)
+
+	type record struct {
+		Level string `json:"level"`
+		Msg   string `json:"msg"`
+		Index *int   `json:"index"`
+		Voice string `json:"voice"`
+	}
+	var got []record
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var rec record
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatalf("%T.Decode(): %v", dec, err)
+		}
+		got = append(got, rec)
+	}
+	index := func(i int) *int { return &i }
+	want := []record{
+		{Level: "DEBUG", Msg: "request started", Voice: "en"},
+		{Level: "DEBUG", Msg: "request finished", Voice: "en"},
+		{Level: "INFO", Msg: "row retried", Index: index(0), Voice: "en"},
+		{Level: "DEBUG", Msg: "request started", Voice: "en"},
+		{Level: "DEBUG", Msg: "request finished", Voice: "en"},
+		{Level: "DEBUG", Msg: "row saved", Index: index(0), Voice: "en"},
+		{Level: "DEBUG", Msg: "request started", Voice: "th"},
+		{Level: "DEBUG", Msg: "request finished", Voice: "th"},
+		{Level: "WARN", Msg: "response parse failed", Voice: "th"},
+		{Level: "INFO", Msg: "row retried", Index: index(1), Voice: "th"},
+		{Level: "DEBUG", Msg: "request started", Voice: "th"},
+		{Level: "DEBUG", Msg: "request finished", Voice: "th"},
+		{Level: "WARN", Msg: "response parse failed", Voice: "th"},
+		{Level: "WARN", Msg: "row failed", Index: index(1), Voice: "th"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("%T.Run(): records diff=\n%s", runner, diff)
+	}
+}
@@ -0,0 +1,209 @@
+package synthesize
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// S3Saver uploads audio as the objects of a bucket of Amazon S3 or a compatible object store such as MinIO,
+// requests are signed with AWS Signature Version 4 and buckets are addressed by path
+type S3Saver struct {
+	// Endpoint is the base URL of the object store such as "http://localhost:9000",
+	// it defaults to the Amazon S3 endpoint of the region
+	Endpoint string
+	Bucket   string
+	// Prefix is put before the name of every object such as "audio/"
+	Prefix string
+	// Region defaults to "us-east-1"
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is needed by temporary credentials only
+	SessionToken string
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// Save uploads the audio as the object named by e and returns the URL of the object
+func (s *S3Saver) Save(ctx context.Context, e Entry, audio io.Reader) (_ string, err error) {
+	body, err := io.ReadAll(audio)
+	if err != nil {
+		return "", fmt.Errorf("io.ReadAll(): %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(e.Name), bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("http.NewRequestWithContext(): %w", err)
+	}
+	req.Header.Set("Content-Type", "audio/mpeg")
+
+	resp, err := s.do(req, body)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		closeErr := resp.Body.Close()
+		if err == nil && closeErr != nil {
+			err = fmt.Errorf("resp.Body.Close(): %w", closeErr)
+		}
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", s3Error(resp)
+	}
+	return req.URL.String(), nil
+}
+
+// Exists reports whether the object named by e exists
+func (s *S3Saver) Exists(ctx context.Context, e Entry) (_ bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.objectURL(e.Name), nil)
+	if err != nil {
+		return false, fmt.Errorf("http.NewRequestWithContext(): %w", err)
+	}
+	resp, err := s.do(req, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		closeErr := resp.Body.Close()
+		if err == nil && closeErr != nil {
+			err = fmt.Errorf("resp.Body.Close(): %w", closeErr)
+		}
+	}()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return false, s3Error(resp)
+	default:
+		return resp.ContentLength != 0, nil
+	}
+}
+
+// objectURL returns the URL of the object named name, the name is escaped so that "?" and "#" are part of the key
+func (s *S3Saver) objectURL(name string) string {
+	key := s.Prefix + filepath.ToSlash(name)
+	u := url.URL{Path: "/" + path.Join(s.Bucket, key)}
+	return strings.TrimSuffix(s.endpoint(), "/") + u.EscapedPath()
+}
+
+func (s *S3Saver) endpoint() string {
+	if s.Endpoint != "" {
+		return s.Endpoint
+	}
+	return "https://s3." + s.region() + ".amazonaws.com"
+}
+
+func (s *S3Saver) region() string {
+	if s.Region != "" {
+		return s.Region
+	}
+	return "us-east-1"
+}
+
+// do signs req with body as its payload and sends it
+func (s *S3Saver) do(req *http.Request, body []byte) (*http.Response, error) {
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	signV4(req, body, s.AccessKeyID, s.SecretAccessKey, s.region(), "s3", time.Now())
+
+	httpClient := s.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%T.Do(): %w", httpClient, err)
+	}
+	return resp, nil
+}
+
+// s3Error returns the error of a response that failed, keeping the start of its body which explains why
+func s3Error(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s %s: status(%d): %s", resp.Request.Method, resp.Request.URL, resp.StatusCode, bytes.TrimSpace(b))
+}
+
+// signV4 signs req and its payload with AWS Signature Version 4 at t,
+// the host and every header that is already set on req are signed, and req is sent with the path escaped the way it is signed
+func signV4(req *http.Request, payload []byte, accessKeyID, secretAccessKey, region, service string, t time.Time) {
+	req.URL.RawPath = escapePath(req.URL.Path)
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	payloadHash := sha256.Sum256(payload)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	req.Header.Del("Authorization")
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	names := slices.Sorted(maps.Keys(headers))
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, strings.TrimSpace(headers[name]))
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.RawPath,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// escapePath escapes p as Signature Version 4 wants it, which is every byte except the unreserved ones and the slashes
+func escapePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	var b strings.Builder
+	for i := range len(p) {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery returns the query sorted by name with every name and value escaped
+func canonicalQuery(query url.Values) string {
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
@@ -0,0 +1,254 @@
+package synthesize
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Entry is the Opt of a batch whose audio is saved
+type Entry struct {
+	// Index is the position of the Opt in the batch counted from 0
+	Index int
+	// Name is the file of the Opt relative to wherever the Saver stores audio, see FilenameFunc
+	Name string
+	Opt  Opt
+}
+
+// Saver stores the audio of the Opts of a batch, it must be safe for concurrent use.
+// A BatchRunner saves to a DirSaver of its output directory unless WithSaver or WithSaveFunc is given
+type Saver interface {
+	// Save stores the audio of e and returns where it is stored such as a path or a URL
+	Save(ctx context.Context, e Entry, audio io.Reader) (string, error)
+}
+
+// Exister is implemented by the Savers that can tell whether the audio of an Entry is already stored,
+// WithSkipExisting needs the Saver to be an Exister unless WithExistsFunc is given
+type Exister interface {
+	Exists(ctx context.Context, e Entry) (bool, error)
+}
+
+// SaverFunc is a function that is a Saver
+type SaverFunc func(ctx context.Context, e Entry, audio io.Reader) (string, error)
+
+// Save calls f
+func (f SaverFunc) Save(ctx context.Context, e Entry, audio io.Reader) (string, error) {
+	return f(ctx, e, audio)
+}
+
+// DirSaver saves audio to files under a directory, creating their directories when needed.
+// Files are written to a temporary file that is synced and renamed over the file,
+// so a crash, a full disk or a cancelled batch never leaves a truncated file behind
+type DirSaver struct {
+	dir string
+}
+
+// NewDirSaver returns a DirSaver that saves under dir
+func NewDirSaver(dir string) *DirSaver {
+	return &DirSaver{dir: dir}
+}
+
+// Save writes the audio to the file named by e under the directory and returns its path,
+// it fails with ErrInvalidAudio without touching the file if the audio isn't a plausible MP3
+func (s *DirSaver) Save(ctx context.Context, e Entry, audio io.Reader) (string, error) {
+	b, err := io.ReadAll(audio)
+	if err != nil {
+		return "", fmt.Errorf("io.ReadAll(): %w", err)
+	}
+	if _, err := ParseMP3(b); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(s.dir, e.Name)
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", fmt.Errorf("os.MkdirAll(%s): %w", dir, err)
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("os.CreateTemp(%s): %w", dir, err)
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if _, err := f.Write(b); err != nil {
+		return "", fmt.Errorf("%T.Write(%s): %w", f, f.Name(), err)
+	}
+	if err := f.Sync(); err != nil {
+		return "", fmt.Errorf("%T.Sync(%s): %w", f, f.Name(), err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("%T.Close(%s): %w", f, f.Name(), err)
+	}
+	// a cancelled batch leaves the file as it was
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return "", fmt.Errorf("os.Rename(%s, %s): %w", f.Name(), path, err)
+	}
+	renamed = true
+	syncDir(dir)
+	return path, nil
+}
+
+// Exists reports whether the file named by e has plausible MP3 audio,
+// files that an older writer left truncated or that hold something else are saved again
+func (s *DirSaver) Exists(_ context.Context, e Entry) (bool, error) {
+	path := filepath.Join(s.dir, e.Name)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("os.ReadFile(%s): %w", path, err)
+	}
+	_, err = ParseMP3(b)
+	return err == nil, nil
+}
+
+// syncDir makes a rename in dir durable, it does its best since some platforms can't sync directories
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
+
+// MemorySaver keeps audio in memory by name, which suits tests and callers that upload the audio themselves
+type MemorySaver struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// NewMemorySaver returns an empty MemorySaver
+func NewMemorySaver() *MemorySaver {
+	return &MemorySaver{files: make(map[string][]byte)}
+}
+
+// Save keeps the audio under the name of e and returns the name
+func (s *MemorySaver) Save(_ context.Context, e Entry, audio io.Reader) (string, error) {
+	b, err := io.ReadAll(audio)
+	if err != nil {
+		return "", fmt.Errorf("io.ReadAll(): %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[e.Name] = b
+	return e.Name, nil
+}
+
+// Exists reports whether the audio of e is kept
+func (s *MemorySaver) Exists(_ context.Context, e Entry) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.files[e.Name]
+	return ok, nil
+}
+
+// Get returns the audio kept under name
+func (s *MemorySaver) Get(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.files[name]
+	return b, ok
+}
+
+// Names returns the sorted names of the kept audio
+func (s *MemorySaver) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Sorted(maps.Keys(s.files))
+}
+
+// ZipSaver writes audio as the files of a zip archive, it must be closed to finish the archive
+type ZipSaver struct {
+	mu    sync.Mutex
+	zw    *zip.Writer
+	saved map[string]bool
+	prev  map[string]*zip.File
+	order []*zip.File
+}
+
+// NewZipSaver returns a ZipSaver that writes the archive to w
+func NewZipSaver(w io.Writer) *ZipSaver {
+	return &ZipSaver{zw: zip.NewWriter(w), saved: make(map[string]bool), prev: make(map[string]*zip.File)}
+}
+
+// Resume carries the files of r, the archive of an earlier batch, over to the archive when it is closed
+// unless they are saved again, so a resumed batch keeps the audio it already has and Exists reports it.
+// r must stay open until the ZipSaver is closed
+func (s *ZipSaver) Resume(r *zip.Reader) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range r.File {
+		if _, ok := s.prev[f.Name]; !ok {
+			s.order = append(s.order, f)
+		}
+		s.prev[f.Name] = f
+	}
+}
+
+// Save adds the audio as the file named by e and returns its name in the archive,
+// the audio is stored as it is since MP3 doesn't compress any further
+func (s *ZipSaver) Save(_ context.Context, e Entry, audio io.Reader) (string, error) {
+	name := filepath.ToSlash(e.Name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, err := s.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return "", fmt.Errorf("%T.CreateHeader(%s): %w", s.zw, name, err)
+	}
+	if _, err := io.Copy(w, audio); err != nil {
+		return "", fmt.Errorf("io.Copy(%s): %w", name, err)
+	}
+	s.saved[name] = true
+	return name, nil
+}
+
+// Exists reports whether the file named by e is saved or carried over from the archive given to Resume
+func (s *ZipSaver) Exists(_ context.Context, e Entry) (bool, error) {
+	name := filepath.ToSlash(e.Name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.saved[name] {
+		return true, nil
+	}
+	f, ok := s.prev[name]
+	return ok && f.UncompressedSize64 > 0, nil
+}
+
+// Close carries the files of the archive given to Resume over and finishes the archive,
+// it doesn't close the underlying writer
+func (s *ZipSaver) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.order {
+		if s.saved[f.Name] {
+			continue
+		}
+		if err := s.zw.Copy(f); err != nil {
+			return fmt.Errorf("%T.Copy(%s): %w", s.zw, f.Name, err)
+		}
+	}
+	if err := s.zw.Close(); err != nil {
+		return fmt.Errorf("%T.Close(): %w", s.zw, err)
+	}
+	return nil
+}
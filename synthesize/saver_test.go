@@ -0,0 +1,271 @@
+package synthesize
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lingua-sensei/laverna/synthesize/synthesizetest"
+	"github.com/mrwormhole/errdiff"
+)
+
+func TestSavers(t *testing.T) {
+	dir := t.TempDir()
+	audio := synthesizetest.Audio("hello", 0)
+	memory := NewMemorySaver()
+	tests := []struct {
+		name  string
+		saver Saver
+		// want is the location of the audio
+		want string
+	}{
+		{
+			name:  "dir",
+			saver: NewDirSaver(dir),
+			want:  filepath.Join(dir, "en", "hello.mp3"),
+		},
+		{
+			name:  "memory",
+			saver: memory,
+			want:  filepath.Join("en", "hello.mp3"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := Entry{Name: filepath.Join("en", "hello.mp3"), Opt: Opt{Text: "hello", Voice: EnglishVoice}}
+			exister := tt.saver.(Exister)
+			if exists, err := exister.Exists(t.Context(), e); err != nil || exists {
+				t.Fatalf("%T.Exists(): got %t, %v before saving, want false", tt.saver, exists, err)
+			}
+			got, err := tt.saver.Save(t.Context(), e, bytes.NewReader(audio))
+			if err != nil {
+				t.Fatalf("%T.Save(): %v", tt.saver, err)
+			}
+			if got != tt.want {
+				t.Errorf("%T.Save(): got = %s, want = %s", tt.saver, got, tt.want)
+			}
+			if exists, err := exister.Exists(t.Context(), e); err != nil || !exists {
+				t.Errorf("%T.Exists(): got %t, %v after saving, want true", tt.saver, exists, err)
+			}
+		})
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, "en", "hello.mp3"))
+	if err != nil || !bytes.Equal(raw, audio) {
+		t.Errorf("os.ReadFile(): got %d bytes, %v, want %d bytes", len(raw), err, len(audio))
+	}
+	if diff := cmp.Diff([]string{filepath.Join("en", "hello.mp3")}, memory.Names()); diff != "" {
+		t.Errorf("%T.Names(): diff=\n%s", memory, diff)
+	}
+	if raw, ok := memory.Get(filepath.Join("en", "hello.mp3")); !ok || !bytes.Equal(raw, audio) {
+		t.Errorf("%T.Get(): got %d bytes, %t, want %d bytes", memory, len(raw), ok, len(audio))
+	}
+}
+
+func TestZipSaver(t *testing.T) {
+	var b bytes.Buffer
+	saver := NewZipSaver(&b)
+	runner := NewBatchRunner(
+		WithSynthesizer(SynthesizerFunc(func(_ context.Context, opt Opt) ([]byte, error) { return []byte(opt.Text), nil })),
+		WithSaver(saver),
+		WithFilenameFunc(func(i int, opt Opt) (string, error) { return filepath.Join(string(opt.Voice), opt.Text+".mp3"), nil }),
+	)
+	opts := []Opt{{Text: "hello", Voice: EnglishVoice}, {Text: "sawasdee", Voice: ThaiVoice}}
+	if err := runner.Run(t.Context(), opts); err != nil {
+		t.Fatalf("%T.Run(): %v", runner, err)
+	}
+	if err := saver.Close(); err != nil {
+		t.Fatalf("%T.Close(): %v", saver, err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader(): %v", err)
+	}
+	got := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("%T.Open(): %v", f, err)
+		}
+		raw, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			t.Fatalf("io.ReadAll(): %v", err)
+		}
+		got[f.Name] = string(raw)
+	}
+	want := map[string]string{"en/hello.mp3": "hello", "th/sawasdee.mp3": "sawasdee"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("zip files diff=\n%s", diff)
+	}
+}
+
+func TestZipSaver_Resume(t *testing.T) {
+	synthesizer := SynthesizerFunc(func(_ context.Context, opt Opt) ([]byte, error) { return []byte(opt.Text), nil })
+	opts := []Opt{{Text: "hello", Voice: EnglishVoice}, {Text: "sawasdee", Voice: ThaiVoice}}
+
+	var prev bytes.Buffer
+	saver := NewZipSaver(&prev)
+	runner := NewBatchRunner(WithSynthesizer(synthesizer), WithSaver(saver))
+	if err := runner.Run(t.Context(), opts[:1]); err != nil {
+		t.Fatalf("%T.Run(): %v", runner, err)
+	}
+	if err := saver.Close(); err != nil {
+		t.Fatalf("%T.Close(): %v", saver, err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(prev.Bytes()), int64(prev.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader(): %v", err)
+	}
+
+	var b bytes.Buffer
+	saver = NewZipSaver(&b)
+	saver.Resume(zr)
+	var synthesized []string
+	runner = NewBatchRunner(
+		WithSynthesizer(SynthesizerFunc(func(ctx context.Context, opt Opt) ([]byte, error) {
+			synthesized = append(synthesized, opt.Text)
+			return synthesizer(ctx, opt)
+		})),
+		WithSaver(saver),
+		WithSkipExisting(),
+		WithMaxWorkers(1),
+	)
+	if err := runner.Run(t.Context(), opts); err != nil {
+		t.Fatalf("%T.Run(): %v", runner, err)
+	}
+	if err := saver.Close(); err != nil {
+		t.Fatalf("%T.Close(): %v", saver, err)
+	}
+	if diff := cmp.Diff([]string{"sawasdee"}, synthesized); diff != "" {
+		t.Errorf("synthesized diff=\n%s", diff)
+	}
+
+	zr, err = zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader(): %v", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if diff := cmp.Diff([]string{"sawasdee_th_normal.mp3", "hello_en_normal.mp3"}, names); diff != "" {
+		t.Errorf("zip files diff=\n%s", diff)
+	}
+}
+
+func TestBatchRunner_WithSkipExisting_NoExister(t *testing.T) {
+	saver := SaverFunc(func(context.Context, Entry, io.Reader) (string, error) { return "", nil })
+	runner := NewBatchRunner(WithSaver(saver), WithSkipExisting())
+	err := runner.Run(t.Context(), []Opt{{Text: "hello", Voice: EnglishVoice}})
+	if diff := errdiff.Check(err, ErrNoExister); diff != "" {
+		t.Errorf("%T.Run(): err diff=\n%s", runner, diff)
+	}
+	err = runner.RunSeq(t.Context(), slices.Values([]Opt{{Text: "hello", Voice: EnglishVoice}}))
+	if diff := errdiff.Check(err, ErrNoExister); diff != "" {
+		t.Errorf("%T.RunSeq(): err diff=\n%s", runner, diff)
+	}
+}
+
+func TestBatchRunner_WithSaver(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		entries []Entry
+	)
+	saver := SaverFunc(func(_ context.Context, e Entry, audio io.Reader) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		entries = append(entries, e)
+		return "memory://" + e.Name, nil
+	})
+	runner := NewBatchRunner(
+		WithSynthesizer(SynthesizerFunc(func(context.Context, Opt) ([]byte, error) { return []byte("audio"), nil })),
+		WithSaver(saver),
+		WithMaxWorkers(1),
+	)
+	opts := []Opt{{Text: "hello", Voice: EnglishVoice}, {Text: "hello", Voice: ThaiVoice, Speed: SlowerSpeed}}
+	results, err := runner.RunResults(t.Context(), opts)
+	if err != nil {
+		t.Fatalf("%T.RunResults(): %v", runner, err)
+	}
+
+	want := []Entry{
+		{Index: 0, Name: "hello_en_normal.mp3", Opt: opts[0]},
+		{Index: 1, Name: "hello_th_slower.mp3", Opt: opts[1]},
+	}
+	if diff := cmp.Diff(want, entries); diff != "" {
+		t.Errorf("%T.Save(): entries diff=\n%s", saver, diff)
+	}
+	if got := results[1].Path; got != "memory://hello_th_slower.mp3" {
+		t.Errorf("%T.RunResults(): got path = %s, want = %s", runner, got, "memory://hello_th_slower.mp3")
+	}
+}
+
+func TestDirSaver_Atomic(t *testing.T) {
+	dir := t.TempDir()
+	saver := NewDirSaver(dir)
+	e := Entry{Name: "hello.mp3", Opt: Opt{Text: "hello", Voice: EnglishVoice}}
+	old := synthesizetest.Audio("hi", 0)
+	if err := os.WriteFile(filepath.Join(dir, e.Name), old, 0600); err != nil {
+		t.Fatalf("os.WriteFile(): %v", err)
+	}
+
+	audio := synthesizetest.Audio("hello", 0)
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		audio   []byte
+		wantErr error
+	}{
+		{
+			name:    "invalid audio",
+			ctx:     t.Context(),
+			audio:   []byte("<html>rate limited</html>"),
+			wantErr: ErrInvalidAudio,
+		},
+		{
+			name:    "cancelled",
+			ctx:     ctx,
+			audio:   audio,
+			wantErr: context.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := saver.Save(tt.ctx, e, bytes.NewReader(tt.audio))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("%T.Save(): got err = %v, want = %v", saver, err, tt.wantErr)
+			}
+			if raw, _ := os.ReadFile(filepath.Join(dir, e.Name)); !bytes.Equal(raw, old) {
+				t.Errorf("%T.Save(): the file was changed by a failed save", saver)
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("os.ReadDir(): %v", err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("os.ReadDir(): got %d files, want only the audio without temporary files", len(entries))
+			}
+		})
+	}
+
+	if _, err := saver.Save(t.Context(), e, bytes.NewReader(audio)); err != nil {
+		t.Fatalf("%T.Save(): %v", saver, err)
+	}
+	if raw, _ := os.ReadFile(filepath.Join(dir, e.Name)); !bytes.Equal(raw, audio) {
+		t.Errorf("%T.Save(): got %d bytes, want %d bytes", saver, len(raw), len(audio))
+	}
+}
@@ -1,5 +1,10 @@
 package synthesize
 
+import (
+	"errors"
+	"strconv"
+)
+
 // Speed is the pronunciation speed of the voice
 type Speed int
 
@@ -26,7 +31,20 @@ func NewSpeed(s string) Speed {
 	}
 }
 
-// String returns the string representation of speed
+// ErrUnknownSpeed occurs when a speed is not one of the speeds
+var ErrUnknownSpeed = errors.New("unknown speed")
+
+var speeds = []string{"normal", "slower", "slowest"}
+
+// Valid reports whether the speed is one of the speeds
+func (s Speed) Valid() bool {
+	return s >= NormalSpeed && int(s) < len(speeds)
+}
+
+// String returns the string representation of speed, unknown speeds are shown as "Speed(3)"
 func (s Speed) String() string {
-	return []string{"normal", "slower", "slowest"}[s]
+	if !s.Valid() {
+		return "Speed(" + strconv.Itoa(int(s)) + ")"
+	}
+	return speeds[s]
 }
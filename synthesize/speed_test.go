@@ -28,3 +28,22 @@ func TestNewSpeed(t *testing.T) {
 		})
 	}
 }
+
+func TestSpeed_String(t *testing.T) {
+	tests := []struct {
+		speed Speed
+		want  string
+	}{
+		{speed: NormalSpeed, want: "normal"},
+		{speed: SlowestSpeed, want: "slowest"},
+		{speed: 3, want: "Speed(3)"},
+		{speed: -1, want: "Speed(-1)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.speed.String(); got != tt.want {
+				t.Errorf("%T.String(): got = %v, want = %v", tt.speed, got, tt.want)
+			}
+		})
+	}
+}
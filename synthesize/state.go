@@ -0,0 +1,117 @@
+package synthesize
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// State records the Opts of a batch that completed in a JSON Lines file, so an interrupted batch can be resumed,
+// an Opt is done if the same voice, speed and text was saved to the same file
+type State struct {
+	mu   sync.Mutex
+	file *os.File
+	done map[string]string // file to the key of its Opt
+}
+
+// stateEntry is a line of the state file
+type stateEntry struct {
+	File string `json:"file"`
+	Key  string `json:"key"`
+}
+
+// OpenState opens the state file at path or creates it, lines that can't be read such as
+// a last line cut short by a crash are ignored since their Opts are simply run again
+func OpenState(path string) (*State, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("os.OpenFile(%s): %w", path, err)
+	}
+
+	s := &State{file: f, done: make(map[string]string)}
+	reader := bufio.NewReader(f)
+	var last byte
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			last = line[len(line)-1]
+		}
+		var entry stateEntry
+		if json.Unmarshal(bytes.TrimSpace(line), &entry) == nil && entry.File != "" {
+			s.done[entry.File] = entry.Key
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("%T.ReadBytes(): %w", reader, err)
+		}
+	}
+	// start on a new line if the last one was cut short
+	if last != 0 && last != '\n' {
+		if _, err := f.Write([]byte("\n")); err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("%T.Write(): %w", f, err)
+		}
+	}
+	return s, nil
+}
+
+// stateKey identifies the audio of an Opt
+func stateKey(opt Opt) string {
+	sum := sha256.Sum256([]byte(string(opt.Voice) + "\x00" + opt.Speed.String() + "\x00" + opt.Text))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Done reports whether opt was already saved to the file with the given name, a nil State has nothing done
+func (s *State) Done(name string, opt Opt) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.done[name]
+	return ok && key == stateKey(opt)
+}
+
+// Add records that opt was saved to the file with the given name, a nil State records nothing
+func (s *State) Add(name string, opt Opt) error {
+	if s == nil {
+		return nil
+	}
+	entry := stateEntry{File: name, Key: stateKey(opt)}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("json.Marshal(%v): %w", entry, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("%T.Write(): %w", s.file, err)
+	}
+	s.done[name] = entry.Key
+	return nil
+}
+
+// Len returns the number of Opts that are done
+func (s *State) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.done)
+}
+
+// Close closes the state file
+func (s *State) Close() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("%T.Close(%s): %w", s.file, s.file.Name(), err)
+	}
+	return nil
+}
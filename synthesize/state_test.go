@@ -0,0 +1,133 @@
+package synthesize
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	hello := Opt{Text: "hello", Voice: EnglishVoice}
+
+	s, err := OpenState(path)
+	if err != nil {
+		t.Fatalf("OpenState(): %v", err)
+	}
+	if s.Done("hello.mp3", hello) {
+		t.Errorf("%T.Done(): got = true for a new state, want = false", s)
+	}
+	if err := s.Add("hello.mp3", hello); err != nil {
+		t.Fatalf("%T.Add(): %v", s, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("%T.Close(): %v", s, err)
+	}
+
+	// a crash in the middle of a line
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("os.OpenFile(): %v", err)
+	}
+	if _, err := f.WriteString(`{"file":"bye.mp3","ke`); err != nil {
+		t.Fatalf("%T.WriteString(): %v", f, err)
+	}
+	f.Close()
+
+	s, err = OpenState(path)
+	if err != nil {
+		t.Fatalf("OpenState(): %v", err)
+	}
+	defer s.Close()
+	if err := s.Add("bye.mp3", Opt{Text: "bye", Voice: EnglishVoice}); err != nil {
+		t.Fatalf("%T.Add(): %v", s, err)
+	}
+
+	tests := []struct {
+		name string
+		file string
+		opt  Opt
+		want bool
+	}{
+		{
+			name: "done",
+			file: "hello.mp3",
+			opt:  hello,
+			want: true,
+		},
+		{
+			name: "added after a cut line",
+			file: "bye.mp3",
+			opt:  Opt{Text: "bye", Voice: EnglishVoice},
+			want: true,
+		},
+		{
+			name: "text changed",
+			file: "hello.mp3",
+			opt:  Opt{Text: "hello!", Voice: EnglishVoice},
+		},
+		{
+			name: "speed changed",
+			file: "hello.mp3",
+			opt:  Opt{Text: "hello", Voice: EnglishVoice, Speed: SlowerSpeed},
+		},
+		{
+			name: "other file",
+			file: "other.mp3",
+			opt:  hello,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.Done(tt.file, tt.opt); got != tt.want {
+				t.Errorf("%T.Done(%q): got = %t, want = %t", s, tt.file, got, tt.want)
+			}
+		})
+	}
+
+	var nilState *State
+	if nilState.Done("hello.mp3", hello) || nilState.Add("hello.mp3", hello) != nil {
+		t.Errorf("nil %T: want nothing done and nothing recorded", nilState)
+	}
+}
+
+func TestBatchRunner_WithState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	opts := []Opt{
+		{Text: "test1", Voice: EnglishVoice},
+		{Text: "test2", Voice: EnglishVoice},
+	}
+
+	run := func(opts []Opt) (synthesized int) {
+		s, err := OpenState(path)
+		if err != nil {
+			t.Fatalf("OpenState(): %v", err)
+		}
+		defer s.Close()
+		runner := NewBatchRunner(
+			WithState(s),
+			WithSynthesizer(SynthesizerFunc(func(_ context.Context, opt Opt) ([]byte, error) {
+				synthesized++
+				return []byte(opt.Text), nil
+			})),
+			WithMaxWorkers(1),
+			WithSaveFunc(func(string, []byte) error { return nil }),
+		)
+		if err := runner.Run(t.Context(), opts); err != nil {
+			t.Fatalf("%T.Run(): %v", runner, err)
+		}
+		return synthesized
+	}
+
+	if got := run(opts[:1]); got != 1 {
+		t.Errorf("first run: got %d synthesized, want %d", got, 1)
+	}
+	if got := run(opts); got != 1 {
+		t.Errorf("resumed run: got %d synthesized, want only the one that wasn't done", got)
+	}
+	if got := run(opts); got != 0 {
+		t.Errorf("finished run: got %d synthesized, want %d", got, 0)
+	}
+}
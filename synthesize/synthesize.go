@@ -3,17 +3,16 @@ package synthesize
 
 import (
 	"bytes"
-	"context"
 	"encoding/base64"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"net/url"
 	"slices"
 	"strings"
+	"unicode/utf16"
 
 	"github.com/goccy/go-yaml"
 )
@@ -23,6 +22,51 @@ type Opt struct {
 	Speed Speed
 	Voice Voice
 	Text  string
+	// File is where the audio is saved relative to the output directory, it overrides the generated filename
+	File string
+	// Extra holds the columns of a CSV row or the keys of a YAML row that aren't part of the parameters
+	Extra map[string]string
+}
+
+// columns are the CSV columns and the YAML keys of the parameters, any other column goes to Opt.Extra
+var columns = []string{"speed", "voice", "text", "file", "id"}
+
+// requiredColumns are the columns every CSV header must have
+var requiredColumns = columns[:3]
+
+// newOpt makes an Opt from the columns of a row, the file column names the audio file
+// and the id column names it after the id when there is no file column
+func newOpt(row map[string]string) Opt {
+	opt := Opt{
+		Speed: NewSpeed(strings.ToLower(row["speed"])),
+		Voice: NewVoice(row["voice"]),
+		Text:  row["text"],
+		File:  row["file"],
+	}
+	if id := row["id"]; opt.File == "" && id != "" {
+		opt.File = id + ".mp3"
+	}
+	for column, value := range row {
+		if slices.Contains(columns, column) {
+			continue
+		}
+		if opt.Extra == nil {
+			opt.Extra = make(map[string]string)
+		}
+		opt.Extra[column] = value
+	}
+	return opt
+}
+
+// stringRow turns the values of a YAML or JSON row into strings, null values are left out
+func stringRow(v map[string]any) map[string]string {
+	row := make(map[string]string, len(v))
+	for key, value := range v {
+		if value != nil {
+			row[key] = fmt.Sprint(value)
+		}
+	}
+	return row
 }
 
 // ErrEmptyYAML occurs when empty yaml is given
@@ -34,21 +78,17 @@ func UnmarshalYAML(raw []byte) ([]Opt, error) {
 		return nil, ErrEmptyYAML
 	}
 
-	type YAML struct {
-		Speed string `yaml:"speed"`
-		Voice string `yaml:"voice"`
-		Text  string `yaml:"text"`
-	}
-	var in []YAML
+	var in []map[string]any
 	if err := yaml.Unmarshal(raw, &in); err != nil {
 		return nil, fmt.Errorf("yaml.Unmarshal(): %w", err)
 	}
 
 	opts := make([]Opt, len(in))
 	for i, v := range in {
-		opts[i].Speed = NewSpeed(strings.ToLower(v.Speed))
-		opts[i].Voice = Voice(strings.ToLower(v.Voice))
-		opts[i].Text = v.Text
+		opts[i] = newOpt(stringRow(v))
+	}
+	if err := checkRows(opts); err != nil {
+		return nil, err
 	}
 	return opts, nil
 }
@@ -56,7 +96,8 @@ func UnmarshalYAML(raw []byte) ([]Opt, error) {
 // ErrEmptyCSV occurs when empty csv is given
 var ErrEmptyCSV = errors.New("empty csv")
 
-// UnmarshalCSV reads raw bytes from CSV and turns into Opts
+// UnmarshalCSV reads raw bytes from CSV and turns into Opts,
+// the header must have the speed, voice and text columns in any order, it may have the file or id columns and extra columns
 func UnmarshalCSV(raw []byte) ([]Opt, error) {
 	if len(raw) == 0 {
 		return nil, ErrEmptyCSV
@@ -64,18 +105,17 @@ func UnmarshalCSV(raw []byte) ([]Opt, error) {
 
 	reader := csv.NewReader(bytes.NewReader(raw))
 	reader.TrimLeadingSpace = true
-	record, err := reader.Read()
+	header, err := reader.Read()
 	if err != nil {
 		return nil, fmt.Errorf("%T.Read(): %v", reader, err)
 	}
-	header := []string{"speed", "voice", "text"}
-	if !slices.Equal(header, record) {
-		return nil, fmt.Errorf("header record(%v) is not the correct header(%v)", record, header)
+	if err := checkHeader(header); err != nil {
+		return nil, err
 	}
 
 	var opts []Opt
 	for {
-		record, err = reader.Read()
+		record, err := reader.Read()
 		if err == io.EOF {
 			break
 		}
@@ -83,20 +123,143 @@ func UnmarshalCSV(raw []byte) ([]Opt, error) {
 			return nil, fmt.Errorf("%T.Read(): %w", reader, err)
 		}
 
-		speed, voice, text := record[0], record[1], record[2]
-		var opt Opt
-		opt.Speed = NewSpeed(strings.ToLower(speed))
-		opt.Voice = Voice(voice)
-		if !strings.Contains(voice, "-") {
-			opt.Voice = Voice(strings.ToLower(voice))
+		row := make(map[string]string, len(header))
+		for i, column := range header {
+			row[column] = record[i]
 		}
-		opt.Text = text
-		opts = append(opts, opt)
+		opts = append(opts, newOpt(row))
+	}
+	if err := checkRows(opts); err != nil {
+		return nil, err
 	}
 	return opts, nil
 }
 
-const rpcID = "jQ1olc"
+// checkHeader reports a CSV header that lacks any of the required columns
+func checkHeader(header []string) error {
+	for _, column := range requiredColumns {
+		if !slices.Contains(header, column) {
+			return fmt.Errorf("header record(%v) is not the correct header(%v)", header, requiredColumns)
+		}
+	}
+	return nil
+}
+
+// checkRows reports every row whose text is too long to be synthesized, rows are counted from 1
+func checkRows(opts []Opt) error {
+	var errs []error
+	for i, opt := range opts {
+		if err := checkRow(i, opt); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkRow reports the row at index if its text is too long to be synthesized
+func checkRow(index int, opt Opt) error {
+	if _, err := chunkText(opt.Text); err != nil {
+		return fmt.Errorf("row(%d): %w", index+1, err)
+	}
+	return nil
+}
+
+// rowColumns returns the columns that opts are written with, the file column is only written when an Opt has a file
+// and the extra columns follow in alphabetical order
+func rowColumns(opts []Opt) []string {
+	header := slices.Clone(requiredColumns)
+	var extra []string
+	for _, opt := range opts {
+		if opt.File != "" && !slices.Contains(header, "file") {
+			header = append(header, "file")
+		}
+		for column := range opt.Extra {
+			if !slices.Contains(extra, column) {
+				extra = append(extra, column)
+			}
+		}
+	}
+	slices.Sort(extra)
+	return append(header, extra...)
+}
+
+// row returns the value of column for opt
+func (opt Opt) row(column string) string {
+	switch column {
+	case "speed":
+		return opt.Speed.String()
+	case "voice":
+		return string(opt.Voice)
+	case "text":
+		return opt.Text
+	case "file":
+		return opt.File
+	default:
+		return opt.Extra[column]
+	}
+}
+
+// MarshalCSV turns Opts into CSV that UnmarshalCSV reads back
+func MarshalCSV(opts []Opt) ([]byte, error) {
+	header := rowColumns(opts)
+	var b bytes.Buffer
+	writer := csv.NewWriter(&b)
+	if err := writer.Write(header); err != nil {
+		return nil, fmt.Errorf("%T.Write(%v): %w", writer, header, err)
+	}
+	for _, opt := range opts {
+		record := make([]string, len(header))
+		for i, column := range header {
+			record[i] = opt.row(column)
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, fmt.Errorf("%T.Write(%v): %w", writer, record, err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, fmt.Errorf("%T.Flush(): %w", writer, err)
+	}
+	return b.Bytes(), nil
+}
+
+// MarshalYAML turns Opts into YAML that UnmarshalYAML reads back
+func MarshalYAML(opts []Opt) ([]byte, error) {
+	header := rowColumns(opts)
+	rows := make([]yaml.MapSlice, len(opts))
+	for i, opt := range opts {
+		for _, column := range header {
+			if value := opt.row(column); value != "" || slices.Contains(requiredColumns, column) {
+				rows[i] = append(rows[i], yaml.MapItem{Key: column, Value: value})
+			}
+		}
+	}
+	raw, err := yaml.Marshal(rows)
+	if err != nil {
+		return nil, fmt.Errorf("yaml.Marshal(): %w", err)
+	}
+	return raw, nil
+}
+
+// MarshalJSONL turns Opts into JSON Lines that NewJSONLDecoder reads back
+func MarshalJSONL(opts []Opt) ([]byte, error) {
+	header := rowColumns(opts)
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	for _, opt := range opts {
+		row := make(map[string]string, len(header))
+		for _, column := range header {
+			if value := opt.row(column); value != "" || slices.Contains(requiredColumns, column) {
+				row[column] = value
+			}
+		}
+		if err := encoder.Encode(row); err != nil {
+			return nil, fmt.Errorf("%T.Encode(%v): %w", encoder, row, err)
+		}
+	}
+	return b.Bytes(), nil
+}
 
 // Request will look as below, since it is a form, the key is f.req
 // and the URL encoded value is going to be
@@ -118,7 +281,7 @@ const rpcID = "jQ1olc"
 		]
 	]
 */
-func makeFormData(opt Opt) (string, error) {
+func makeFormData(rpcID string, opt Opt) (string, error) {
 	genericOpts := []any{opt.Text, opt.Voice, nil, nil, []Speed{opt.Speed}}
 	rawOpts, err := json.Marshal(genericOpts)
 	if err != nil {
@@ -140,6 +303,9 @@ func makeFormData(opt Opt) (string, error) {
 	return form.Encode(), nil
 }
 
+// ErrNoAudio occurs when the response has no audio, which happens when it is an error envelope or it is cut short
+var ErrNoAudio = errors.New("no audio in response")
+
 // Response will look as below, this function parses base64 data to MP3 format
 /*
 	)]}'
@@ -175,7 +341,7 @@ func parseAudio(raw []byte) ([]byte, error) {
 	}
 
 	if audioLine == "" {
-		return nil, errors.New("no audio line found")
+		return nil, fmt.Errorf("no audio line found: %w", ErrNoAudio)
 	}
 
 	var audioLineSubParts [][]any
@@ -185,7 +351,7 @@ func parseAudio(raw []byte) ([]byte, error) {
 
 	audioLineSubPart, ok := audioLineSubParts[0][2].(string)
 	if !ok {
-		return nil, errors.New("no audio line sub part found")
+		return nil, fmt.Errorf("no audio line sub part found: %w", ErrNoAudio)
 	}
 
 	var base64EncodedAudio []string
@@ -194,7 +360,7 @@ func parseAudio(raw []byte) ([]byte, error) {
 	}
 
 	if len(base64EncodedAudio) == 0 {
-		return nil, errors.New("no base64 encoded audio found")
+		return nil, fmt.Errorf("no base64 encoded audio found: %w", ErrNoAudio)
 	}
 
 	audio, err := base64.StdEncoding.DecodeString(base64EncodedAudio[0])
@@ -204,50 +370,28 @@ func parseAudio(raw []byte) ([]byte, error) {
 	return audio, nil
 }
 
-const hostname = "https://translate.google.com"
-
-// ErrTextTooLong occurs when given text is longer than 200 characters
-var ErrTextTooLong = errors.New("text must be less than 200 chars")
-
-// Run produces the audio with a http client and a given option
-func Run(ctx context.Context, c *http.Client, opt Opt) (_ []byte, err error) {
-	const URL = hostname + "/_/TranslateWebserverUi/data/batchexecute"
-
-	if len(opt.Text) > 200 {
-		return nil, ErrTextTooLong
-	}
-	if c == nil {
-		c = http.DefaultClient
-	}
+// MaxTextLength is the longest text in characters the upstream accepts in a single request
+const MaxTextLength = 200
 
-	formData, err := makeFormData(opt)
-	if err != nil {
-		return nil, fmt.Errorf("makeFormData(%v): %v", opt, err)
+// TextLength returns the length of text the way the upstream measures it, which is in UTF-16 code units
+func TextLength(text string) int {
+	var n int
+	for _, r := range text {
+		n += utf16.RuneLen(r)
 	}
+	return n
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, URL, bytes.NewBufferString(formData))
-	if err != nil {
-		return nil, fmt.Errorf("http.NewRequestWithContext(): %v", err)
-	}
+// ErrTextTooLong occurs when given text contains a word that is longer than MaxTextLength and can't be chunked
+var ErrTextTooLong = errors.New("text must be less than 200 chars")
 
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Accept", "*/*")
-	req.Header.Set("Origin", hostname)
-	req.Header.Set("Referer", hostname)
-	resp, err := c.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("%T.Do(): %w", c, err)
-	}
-	defer func() {
-		closeErr := resp.Body.Close()
-		if err == nil && closeErr != nil {
-			err = fmt.Errorf("resp.Body.Close(): %v", closeErr)
+// chunkText chunks text into pieces that fit in MaxTextLength, it reports ErrTextTooLong if that's not possible
+func chunkText(text string) ([]string, error) {
+	chunks := Chunk(text, MaxTextLength)
+	for _, chunk := range chunks {
+		if n := TextLength(chunk); n > MaxTextLength {
+			return nil, fmt.Errorf("chunk(%q) length(%d): %w", chunk, n, ErrTextTooLong)
 		}
-	}()
-
-	raw, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("io.ReadAll(): %v", err)
 	}
-	return parseAudio(raw)
+	return chunks, nil
 }
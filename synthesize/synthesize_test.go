@@ -1,18 +1,33 @@
 package synthesize
 
 import (
+	"bytes"
 	"encoding/csv"
 	"errors"
+	"maps"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/lingua-sensei/laverna/synthesize/synthesizetest"
 	"github.com/mrwormhole/errdiff"
 )
 
 func TestRun(t *testing.T) {
+	server := synthesizetest.NewServer()
+	defer server.Close()
+
+	longText := strings.Repeat("สวัสดีชาวโลก วันนี้เราจะมาพูดคุยกันถึงปัญหาของโลก ", 5)
+	var longTextBytes int
+	for _, chunk := range Chunk(longText, MaxTextLength) {
+		longTextBytes += len(synthesizetest.Audio(chunk, int(SlowestSpeed)))
+	}
+
 	tests := []struct {
 		name      string
 		client    *http.Client
@@ -22,23 +37,34 @@ func TestRun(t *testing.T) {
 	}{
 		{
 			name:   "normal case",
-			client: &http.Client{},
+			client: server.Client(),
 			opt: Opt{
 				Text:  "สวัสดีชาวโลก วันนี้เราจะมาพูดคุยกันถึงปัญหาของโลก",
 				Voice: ThaiVoice,
 				Speed: SlowestSpeed,
 			},
 			wantErr:   nil,
-			wantBytes: 66240,
+			wantBytes: len(synthesizetest.Audio("สวัสดีชาวโลก วันนี้เราจะมาพูดคุยกันถึงปัญหาของโลก", int(SlowestSpeed))),
 		},
 		{
-			name:   "text too long",
-			client: nil,
+			name:   "long text is chunked",
+			client: server.Client(),
 			opt: Opt{
-				Text:  strings.Repeat("สวัสดีชาวโลก", 200),
+				Text:  longText,
 				Voice: ThaiVoice,
 				Speed: SlowestSpeed,
 			},
+			wantErr:   nil,
+			wantBytes: longTextBytes,
+		},
+		{
+			name:   "word too long",
+			client: nil,
+			opt: Opt{
+				Text:  strings.Repeat("hello", 50),
+				Voice: EnglishVoice,
+				Speed: SlowestSpeed,
+			},
 			wantErr:   ErrTextTooLong,
 			wantBytes: 0,
 		},
@@ -57,6 +83,102 @@ func TestRun(t *testing.T) {
 	}
 }
 
+func TestRun_Faults(t *testing.T) {
+	tests := []struct {
+		name           string
+		fault          synthesizetest.Fault
+		wantErr        error
+		wantStatus     int
+		wantRetryAfter time.Duration
+	}{
+		{
+			name:    "error envelope",
+			fault:   synthesizetest.ErrorEnvelope,
+			wantErr: ErrNoAudio,
+		},
+		{
+			name:    "malformed body",
+			fault:   synthesizetest.MalformedBody,
+			wantErr: ErrNoAudio,
+		},
+		{
+			name:    "truncated body",
+			fault:   synthesizetest.TruncatedBody,
+			wantErr: ErrNoAudio,
+		},
+		{
+			name:    "not audio",
+			fault:   synthesizetest.NotAudio,
+			wantErr: ErrInvalidAudio,
+		},
+		{
+			name:           "rate limited",
+			fault:          synthesizetest.RateLimited,
+			wantErr:        ErrRateLimited,
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: time.Second,
+		},
+		{
+			name:       "unavailable",
+			fault:      synthesizetest.Unavailable,
+			wantErr:    ErrUnavailable,
+			wantStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := synthesizetest.NewServer(synthesizetest.WithFaults(tt.fault))
+			defer server.Close()
+
+			opt := Opt{Text: "hello", Voice: EnglishVoice}
+			audio, err := Run(t.Context(), server.Client(), opt)
+			if diff := errdiff.Check(err, tt.wantErr); diff != "" {
+				t.Errorf("Run(%v): err diff=\n%s", opt, diff)
+			}
+			if len(audio) != 0 {
+				t.Errorf("Run(%v): got %d bytes, want no audio", opt, len(audio))
+			}
+
+			var upstreamErr *UpstreamError
+			if errors.As(err, &upstreamErr) != (tt.wantStatus != 0) {
+				t.Fatalf("Run(%v): got err = %v, want %T = %v", opt, err, upstreamErr, tt.wantStatus != 0)
+			}
+			if upstreamErr == nil {
+				return
+			}
+			if upstreamErr.StatusCode != tt.wantStatus {
+				t.Errorf("%T.StatusCode: got = %d, want = %d", upstreamErr, upstreamErr.StatusCode, tt.wantStatus)
+			}
+			if upstreamErr.RetryAfter != tt.wantRetryAfter {
+				t.Errorf("%T.RetryAfter: got = %v, want = %v", upstreamErr, upstreamErr.RetryAfter, tt.wantRetryAfter)
+			}
+			if upstreamErr.Body == "" {
+				t.Errorf("%T.Body: got empty body, want body", upstreamErr)
+			}
+		})
+	}
+}
+
+func TestTextLength(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{text: "Hello there", want: 11},
+		{text: "สวัสดีครับ", want: 10},
+		{text: "こんにちは~", want: 6},
+		{text: "𝄞 clef", want: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := TextLength(tt.text); got != tt.want {
+				t.Errorf("TextLength(%q): got = %d, want = %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestUnmarshalYAML(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -92,6 +214,30 @@ func TestUnmarshalYAML(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "extra keys",
+			rawYAML: func() []byte {
+				return []byte("- speed: slower\n  voice: zh-tw\n  text: 你好\n  lesson: 1\n  note:\n")
+			},
+			wantOpts: []Opt{
+				{
+					Speed: SlowerSpeed,
+					Voice: ChineseTraditionalVoice,
+					Text:  "你好",
+					Extra: map[string]string{"lesson": "1"},
+				},
+			},
+		},
+		{
+			name: "file and id keys",
+			rawYAML: func() []byte {
+				return []byte("- voice: en\n  text: Hello\n  file: greetings/hello.mp3\n- voice: en\n  text: Bye\n  id: 42\n")
+			},
+			wantOpts: []Opt{
+				{Speed: NormalSpeed, Voice: EnglishVoice, Text: "Hello", File: "greetings/hello.mp3"},
+				{Speed: NormalSpeed, Voice: EnglishVoice, Text: "Bye", File: "42.mp3"},
+			},
+		},
 		{
 			name: "empty YAML",
 			rawYAML: func() []byte {
@@ -99,6 +245,13 @@ func TestUnmarshalYAML(t *testing.T) {
 			},
 			wantErr: ErrEmptyYAML,
 		},
+		{
+			name: "word too long",
+			rawYAML: func() []byte {
+				return []byte("- speed: normal\n  voice: en\n  text: hi\n- speed: normal\n  voice: en\n  text: " + strings.Repeat("hello", 50))
+			},
+			wantErr: ErrTextTooLong,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -149,6 +302,37 @@ func TestUnmarshalCSV(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "columns in any order with extra columns",
+			rawCSV: func() []byte {
+				return []byte("lesson,text,voice,speed\nintro,Hello there,EN,slower\nintro,สวัสดีครับ,th,")
+			},
+			wantOpts: []Opt{
+				{
+					Speed: SlowerSpeed,
+					Voice: EnglishVoice,
+					Text:  "Hello there",
+					Extra: map[string]string{"lesson": "intro"},
+				},
+				{
+					Speed: NormalSpeed,
+					Voice: ThaiVoice,
+					Text:  "สวัสดีครับ",
+					Extra: map[string]string{"lesson": "intro"},
+				},
+			},
+		},
+		{
+			name: "file and id columns",
+			rawCSV: func() []byte {
+				return []byte("speed,voice,text,file,id\nnormal,en,Hello,greetings/hello.mp3,\nnormal,en,Bye,,bye-01\nnormal,en,Both,both.mp3,ignored")
+			},
+			wantOpts: []Opt{
+				{Speed: NormalSpeed, Voice: EnglishVoice, Text: "Hello", File: "greetings/hello.mp3"},
+				{Speed: NormalSpeed, Voice: EnglishVoice, Text: "Bye", File: "bye-01.mp3"},
+				{Speed: NormalSpeed, Voice: EnglishVoice, Text: "Both", File: "both.mp3"},
+			},
+		},
 		{
 			name: "empty csv",
 			rawCSV: func() []byte {
@@ -170,6 +354,13 @@ func TestUnmarshalCSV(t *testing.T) {
 			},
 			wantErr: csv.ErrFieldCount,
 		},
+		{
+			name: "word too long",
+			rawCSV: func() []byte {
+				return []byte("speed,voice,text\nnormal,th," + strings.Repeat("สวัสดี", 50) + "\nnormal,en," + strings.Repeat("hello", 50))
+			},
+			wantErr: ErrTextTooLong,
+		},
 		{
 			name: "invalid csv",
 			rawCSV: func() []byte {
@@ -191,3 +382,56 @@ func TestUnmarshalCSV(t *testing.T) {
 		})
 	}
 }
+
+func TestMarshal(t *testing.T) {
+	opts := []Opt{
+		{Speed: SlowerSpeed, Voice: EnglishVoice, Text: "Hello, \"there\"", Extra: map[string]string{"lesson": "01"}},
+		{Speed: SlowestSpeed, Voice: ThaiVoice, Text: "สวัสดีครับ", File: "th/hello.mp3", Extra: map[string]string{"note": "polite"}},
+		{Voice: JapaneseVoice, Text: "こんにちは~"},
+	}
+
+	tests := []struct {
+		name      string
+		marshal   func([]Opt) ([]byte, error)
+		unmarshal func([]byte) ([]Opt, error)
+	}{
+		{
+			name:      "CSV",
+			marshal:   MarshalCSV,
+			unmarshal: UnmarshalCSV,
+		},
+		{
+			name:      "YAML",
+			marshal:   MarshalYAML,
+			unmarshal: UnmarshalYAML,
+		},
+		{
+			name:    "JSONL",
+			marshal: MarshalJSONL,
+			unmarshal: func(raw []byte) ([]Opt, error) {
+				d := NewJSONLDecoder(bytes.NewReader(raw))
+				return slices.Collect(d.All()), d.Err()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := tt.marshal(opts)
+			if err != nil {
+				t.Fatalf("marshal(): %v", err)
+			}
+			got, err := tt.unmarshal(raw)
+			if err != nil {
+				t.Fatalf("unmarshal(%s): %v", raw, err)
+			}
+			// CSV rows get every extra column of the file, empty ones included
+			for i := range got {
+				maps.DeleteFunc(got[i].Extra, func(_, v string) bool { return v == "" })
+			}
+			if diff := cmp.Diff(opts, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unmarshal(marshal()): opts diff=\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package synthesize
+
+import "context"
+
+// Synthesizer produces the audio of given text with the voice and the speed of an Opt,
+// a BatchRunner uses a Client with its HTTP client unless WithSynthesizer is given
+type Synthesizer interface {
+	Synthesize(ctx context.Context, opt Opt) ([]byte, error)
+}
+
+// SynthesizerFunc is an adapter to use ordinary functions as a Synthesizer
+type SynthesizerFunc func(ctx context.Context, opt Opt) ([]byte, error)
+
+// Synthesize calls f(ctx, opt)
+func (f SynthesizerFunc) Synthesize(ctx context.Context, opt Opt) ([]byte, error) {
+	return f(ctx, opt)
+}
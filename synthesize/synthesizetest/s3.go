@@ -0,0 +1,145 @@
+package synthesizetest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// S3Server is a fake object store that speaks enough of the S3 API to put, head and get objects by path,
+// it checks the AWS Signature Version 4 of every request like MinIO does and keeps the objects in memory
+type S3Server struct {
+	*httptest.Server
+
+	accessKeyID, secretAccessKey string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+// NewS3Server starts a fake object store that accepts requests signed with given credentials,
+// callers should Close it when done
+func NewS3Server(accessKeyID, secretAccessKey string) *S3Server {
+	s := &S3Server{
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		objects:         make(map[string][]byte),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Object returns the object at key in bucket
+func (s *S3Server) Object(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.objects[bucket+"/"+key]
+	return b, ok
+}
+
+func (s *S3Server) handle(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.verify(r, body); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = fmt.Fprintf(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>%s</Message></Error>", err)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		s.objects[name] = body
+	case http.MethodHead, http.MethodGet:
+		object, ok := s.objects[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(object)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// verify checks the signature of r, it only supports requests without a query
+func (s *S3Server) verify(r *http.Request, body []byte) error {
+	var credential, signedHeaders, signature string
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return fmt.Errorf("authorization(%s) must be AWS4-HMAC-SHA256", r.Header.Get("Authorization"))
+	}
+	for _, field := range strings.Split(auth, ", ") {
+		name, value, _ := strings.Cut(field, "=")
+		switch name {
+		case "Credential":
+			credential = value
+		case "SignedHeaders":
+			signedHeaders = value
+		case "Signature":
+			signature = value
+		}
+	}
+	accessKeyID, scope, _ := strings.Cut(credential, "/")
+	if accessKeyID != s.accessKeyID {
+		return fmt.Errorf("access key(%s) is unknown", accessKeyID)
+	}
+	parts := strings.Split(scope, "/")
+	if len(parts) != 4 {
+		return fmt.Errorf("scope(%s) is malformed", scope)
+	}
+
+	payloadHash := sha256.Sum256(body)
+	if got := r.Header.Get("X-Amz-Content-Sha256"); got != hex.EncodeToString(payloadHash[:]) {
+		return fmt.Errorf("content hash(%s) doesn't match the body", got)
+	}
+
+	var headers strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		fmt.Fprintf(&headers, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		"",
+		headers.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + r.Header.Get("X-Amz-Date") + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.secretAccessKey)
+	for _, part := range parts {
+		key = sign(key, part)
+	}
+	if want := hex.EncodeToString(sign(key, stringToSign)); signature != want {
+		return fmt.Errorf("signature(%s) doesn't match", signature)
+	}
+	return nil
+}
+
+func sign(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
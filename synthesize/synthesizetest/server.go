@@ -0,0 +1,245 @@
+// Package synthesizetest provides a fake batchexecute server for testing synthesize without network access.
+// Point synthesize.Client.BaseURL at Server.URL, or pass Server.Client to synthesize.Run.
+package synthesizetest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Path is the path of the batchexecute endpoint
+const Path = "/_/TranslateWebserverUi/data/batchexecute"
+
+// RPCID is the RPC id of the text to speech call
+const RPCID = "jQ1olc"
+
+// Request is a synthesize request the server received
+type Request struct {
+	Text  string
+	Voice string
+	Speed int
+}
+
+// Fault is a failure the server replies with instead of audio
+type Fault int
+
+const (
+	// NoFault replies with audio
+	NoFault Fault = iota
+	// ErrorEnvelope replies with a well formed envelope that carries no audio
+	ErrorEnvelope
+	// RateLimited replies with HTTP 429 and a Retry-After header
+	RateLimited
+	// Unavailable replies with HTTP 503
+	Unavailable
+	// MalformedBody replies with a body that is not an envelope
+	MalformedBody
+	// TruncatedBody replies with an envelope that is cut in the middle
+	TruncatedBody
+	// NotAudio replies with a well formed envelope whose payload isn't MP3
+	NotAudio
+)
+
+// Server is a fake batchexecute server
+type Server struct {
+	*httptest.Server
+
+	latency time.Duration
+
+	mu       sync.Mutex
+	faults   []Fault
+	requests []Request
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithLatency delays every reply
+func WithLatency(d time.Duration) Option {
+	return func(s *Server) {
+		s.latency = d
+	}
+}
+
+// WithFaults makes the first requests fail with given faults in order, the requests after them succeed
+func WithFaults(faults ...Fault) Option {
+	return func(s *Server) {
+		s.faults = append(s.faults, faults...)
+	}
+}
+
+// NewServer starts a fake batchexecute server, callers should Close it when done
+func NewServer(opts ...Option) *Server {
+	s := &Server{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Client returns a HTTP client that sends every request to the server regardless of its host,
+// so synthesize.Run with the default translate.google.com URL can be used against it
+func (s *Server) Client() *http.Client {
+	target, err := url.Parse(s.URL)
+	if err != nil {
+		panic(fmt.Sprintf("url.Parse(%s): %v", s.URL, err))
+	}
+
+	c := s.Server.Client()
+	transport := c.Transport
+	c.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = target.Scheme
+		req.URL.Host = target.Host
+		req.Host = target.Host
+		return transport.RoundTrip(req)
+	})
+	return c
+}
+
+// Requests returns the requests the server received so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != Path {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, err := parseRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	fault := NoFault
+	if len(s.faults) > 0 {
+		fault, s.faults = s.faults[0], s.faults[1:]
+	}
+	s.mu.Unlock()
+
+	if s.latency > 0 {
+		select {
+		case <-time.After(s.latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	switch fault {
+	case RateLimited:
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+	case Unavailable:
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+	case ErrorEnvelope:
+		writeEnvelope(w, `["wrb.fr","`+RPCID+`",null,null,null,[3],"generic"]`)
+	case MalformedBody:
+		_, _ = w.Write([]byte("<html>not an envelope</html>"))
+	case NotAudio:
+		payload, _ := json.Marshal([]string{base64.StdEncoding.EncodeToString([]byte("<html>not audio</html>"))})
+		quoted, _ := json.Marshal(string(payload))
+		writeEnvelope(w, `["wrb.fr","`+RPCID+`",`+string(quoted)+`,null,null,null,"generic"]`)
+	case TruncatedBody:
+		var b strings.Builder
+		writeEnvelope(&b, audioEntry(req))
+		_, _ = w.Write([]byte(b.String()[:b.Len()/2]))
+	default:
+		writeEnvelope(w, audioEntry(req))
+	}
+}
+
+// parseRequest decodes the f.req form value, which looks like
+// [[["jQ1olc","[\"text\",\"voice\",null,null,[speed]]",null,"generic"]]]
+func parseRequest(r *http.Request) (Request, error) {
+	if err := r.ParseForm(); err != nil {
+		return Request{}, fmt.Errorf("%T.ParseForm(): %v", r, err)
+	}
+
+	var data [][][]any
+	if err := json.Unmarshal([]byte(r.PostForm.Get("f.req")), &data); err != nil {
+		return Request{}, fmt.Errorf("json.Unmarshal(f.req): %v", err)
+	}
+	if len(data) != 1 || len(data[0]) != 1 || len(data[0][0]) != 4 {
+		return Request{}, fmt.Errorf("f.req(%v) has unexpected shape", data)
+	}
+	call := data[0][0]
+	if call[0] != RPCID {
+		return Request{}, fmt.Errorf("rpc id(%v) is not %s", call[0], RPCID)
+	}
+	rawOpts, ok := call[1].(string)
+	if !ok {
+		return Request{}, fmt.Errorf("rpc payload(%v) is not a string", call[1])
+	}
+
+	var opts []any
+	if err := json.Unmarshal([]byte(rawOpts), &opts); err != nil {
+		return Request{}, fmt.Errorf("json.Unmarshal(%s): %v", rawOpts, err)
+	}
+	if len(opts) != 5 {
+		return Request{}, fmt.Errorf("rpc payload(%v) has unexpected shape", opts)
+	}
+	text, textOK := opts[0].(string)
+	voice, voiceOK := opts[1].(string)
+	speeds, speedsOK := opts[4].([]any)
+	if !textOK || !voiceOK || !speedsOK || len(speeds) != 1 {
+		return Request{}, fmt.Errorf("rpc payload(%v) has unexpected types", opts)
+	}
+	speed, ok := speeds[0].(float64)
+	if !ok {
+		return Request{}, fmt.Errorf("speed(%v) is not a number", speeds[0])
+	}
+	return Request{Text: text, Voice: voice, Speed: int(speed)}, nil
+}
+
+func audioEntry(req Request) string {
+	payload, _ := json.Marshal([]string{base64.StdEncoding.EncodeToString(Audio(req.Text, req.Speed))})
+	quoted, _ := json.Marshal(string(payload))
+	return `["wrb.fr","` + RPCID + `",` + string(quoted) + `,null,null,null,"generic"]`
+}
+
+// writeEnvelope writes entry the way batchexecute frames its replies
+func writeEnvelope(w io.Writer, entry string) {
+	body := "[" + entry + `,["di",42],["af.httprm",41,"-5185712416226040017",1]]`
+	_, _ = fmt.Fprintf(w, ")]}'\n\n%d\n%s\n25\n[[\"e\",4,null,null,%d]]\n", len(body), body, len(body))
+}
+
+// frame is a silent MPEG-2 layer III mono frame at 32kbps and 24kHz, the format upstream replies with
+var frame = func() []byte {
+	b := make([]byte, 96)
+	copy(b, []byte{0xFF, 0xF3, 0x44, 0xC4})
+	return b
+}()
+
+// Audio returns the MP3 the server replies with for given text and speed,
+// it has 4 frames for every character of the text at normal speed and one more for every slower speed step
+func Audio(text string, speed int) []byte {
+	n := utf8.RuneCountInString(text) * (4 + speed)
+	return []byte(strings.Repeat(string(frame), max(n, 1)))
+}
@@ -0,0 +1,107 @@
+package synthesizetest_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lingua-sensei/laverna/synthesize"
+	"github.com/lingua-sensei/laverna/synthesize/synthesizetest"
+)
+
+func TestServer(t *testing.T) {
+	server := synthesizetest.NewServer(synthesizetest.WithFaults(synthesizetest.Unavailable))
+	defer server.Close()
+
+	opt := synthesize.Opt{Text: "こんにちは~", Voice: synthesize.JapaneseVoice, Speed: synthesize.SlowerSpeed}
+	if _, err := synthesize.Run(t.Context(), server.Client(), opt); err == nil {
+		t.Errorf("synthesize.Run(%v): got err = nil, want fault", opt)
+	}
+
+	audio, err := synthesize.Run(t.Context(), server.Client(), opt)
+	if err != nil {
+		t.Fatalf("synthesize.Run(%v): %v", opt, err)
+	}
+	if want := synthesizetest.Audio(opt.Text, int(opt.Speed)); !bytes.Equal(audio, want) {
+		t.Errorf("synthesize.Run(%v): got %d bytes, want %d bytes", opt, len(audio), len(want))
+	}
+
+	want := []synthesizetest.Request{
+		{Text: "こんにちは~", Voice: "ja", Speed: 1},
+		{Text: "こんにちは~", Voice: "ja", Speed: 1},
+	}
+	if diff := cmp.Diff(want, server.Requests()); diff != "" {
+		t.Errorf("%T.Requests(): diff=\n%s", server, diff)
+	}
+}
+
+func TestServer_BadRequest(t *testing.T) {
+	server := synthesizetest.NewServer()
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		path       string
+		freq       string
+		wantStatus int
+	}{
+		{
+			name:       "unknown path",
+			path:       "/unknown",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "missing f.req",
+			path:       synthesizetest.Path,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown rpc id",
+			path:       synthesizetest.Path,
+			freq:       `[[["abc","[\"hello\",\"en\",null,null,[0]]",null,"generic"]]]`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "bad payload",
+			path:       synthesizetest.Path,
+			freq:       `[[["jQ1olc","[\"hello\"]",null,"generic"]]]`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"f.req": {tt.freq}}
+			resp, err := server.Client().Post(server.URL+tt.path, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+			if err != nil {
+				t.Fatalf("%T.Post(): %v", server.Client(), err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("%T.Post(): got status = %d, want status = %d", server.Client(), resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+	if got := server.Requests(); len(got) != 0 {
+		t.Errorf("%T.Requests(): got %d requests, want none", server, len(got))
+	}
+}
+
+func TestServer_Latency(t *testing.T) {
+	const latency = 50 * time.Millisecond
+	server := synthesizetest.NewServer(synthesizetest.WithLatency(latency))
+	defer server.Close()
+
+	start := time.Now()
+	opt := synthesize.Opt{Text: "hello", Voice: synthesize.EnglishVoice}
+	if _, err := synthesize.Run(t.Context(), server.Client(), opt); err != nil {
+		t.Fatalf("synthesize.Run(%v): %v", opt, err)
+	}
+	if elapsed := time.Since(start); elapsed < latency {
+		t.Errorf("synthesize.Run(%v): took %v, want at least %v", opt, elapsed, latency)
+	}
+}
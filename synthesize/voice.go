@@ -1,5 +1,11 @@
 package synthesize
 
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
 // Voice represents ISO-639 language codes
 // Taken from https://cloud.google.com/translate/docs/languages
 // es-MX, es-ES or en-US, en-UK, en-AU voices are converted to regions and no longer available on web version, they are using different domains rather than language codes.
@@ -72,3 +78,94 @@ const (
 	VietnameseVoice          Voice = "vi"
 	WelshVoice               Voice = "cy"
 )
+
+var voices = []Voice{
+	AfrikaansVoice,
+	AlbanianVoice,
+	AmharicVoice,
+	ArabicVoice,
+	BengaliVoice,
+	BosnianVoice,
+	BulgarianVoice,
+	CantoneseVoice,
+	CatalanVoice,
+	ChineseSimplifiedVoice,
+	ChineseTraditionalVoice,
+	CroatianVoice,
+	CzechVoice,
+	DanishVoice,
+	DutchVoice,
+	EnglishVoice,
+	EstonianVoice,
+	FilipinoVoice,
+	FinnishVoice,
+	FrenchVoice,
+	FrenchCanadianVoice,
+	GalicianVoice,
+	GermanVoice,
+	GreekVoice,
+	GujaratiVoice,
+	HausaVoice,
+	HebrewVoice,
+	HindiVoice,
+	HungarianVoice,
+	IcelandicVoice,
+	IndonesianVoice,
+	ItalianVoice,
+	JapaneseVoice,
+	JavaneseVoice,
+	KhmerVoice,
+	KoreanVoice,
+	LatinVoice,
+	LatvianVoice,
+	LithuanianVoice,
+	MalayVoice,
+	MalayalamVoice,
+	MarathiVoice,
+	MyanmarVoice,
+	NepaliVoice,
+	NorwegianVoice,
+	PolishVoice,
+	PortugueseBrazilianVoice,
+	PortugueseVoice,
+	PunjabiVoice,
+	RomanianVoice,
+	RussianVoice,
+	SerbianVoice,
+	SinhalaVoice,
+	SlovakVoice,
+	SpanishVoice,
+	SundaneseVoice,
+	SwahiliVoice,
+	SwedishVoice,
+	TamilVoice,
+	TeluguVoice,
+	ThaiVoice,
+	UkrainianVoice,
+	UrduVoice,
+	VietnameseVoice,
+	WelshVoice,
+}
+
+// Voices returns all the voices
+func Voices() []Voice {
+	return slices.Clone(voices)
+}
+
+// NewVoice returns the voice matching s regardless of its case, unknown voices are returned as they are
+func NewVoice(s string) Voice {
+	for _, v := range voices {
+		if strings.EqualFold(string(v), s) {
+			return v
+		}
+	}
+	return Voice(s)
+}
+
+// ErrUnknownVoice occurs when a voice is not one of the voices
+var ErrUnknownVoice = errors.New("unknown voice")
+
+// Valid reports whether the voice is one of the voices
+func (v Voice) Valid() bool {
+	return slices.Contains(voices, v)
+}
@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/lingua-sensei/laverna/synthesize/synthesizetest"
 	"github.com/mrwormhole/errdiff"
 )
 
@@ -77,6 +79,8 @@ var testVoices = []Voice{
 
 func TestRun_AllVoices(t *testing.T) {
 	t.Parallel()
+	server := synthesizetest.NewServer()
+	t.Cleanup(server.Close)
 
 	type Test struct {
 		name    string
@@ -94,7 +98,7 @@ func TestRun_AllVoices(t *testing.T) {
 		}
 		tests = append(tests, Test{
 			name:    "language voice:" + string(opt.Voice),
-			client:  &http.Client{},
+			client:  server.Client(),
 			opt:     opt,
 			wantErr: nil,
 		})
@@ -114,3 +118,34 @@ func TestRun_AllVoices(t *testing.T) {
 		})
 	}
 }
+
+func TestNewVoice(t *testing.T) {
+	tests := []struct {
+		str       string
+		want      Voice
+		wantValid bool
+	}{
+		{str: "th", want: ThaiVoice, wantValid: true},
+		{str: "EN", want: EnglishVoice, wantValid: true},
+		{str: "zh-tw", want: ChineseTraditionalVoice, wantValid: true},
+		{str: "pt-PT", want: PortugueseVoice, wantValid: true},
+		{str: "xx", want: Voice("xx"), wantValid: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			got := NewVoice(tt.str)
+			if got != tt.want {
+				t.Errorf("NewVoice(%v): got = %v, want = %v", tt.str, got, tt.want)
+			}
+			if got.Valid() != tt.wantValid {
+				t.Errorf("%T.Valid(): got = %v, want = %v", got, got.Valid(), tt.wantValid)
+			}
+		})
+	}
+}
+
+func TestVoices(t *testing.T) {
+	if diff := cmp.Diff(testVoices, Voices()); diff != "" {
+		t.Errorf("Voices(): diff=\n%s", diff)
+	}
+}